@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kabinasoftware/jobs-agg/config"
+)
+
+func main() {
+	config.RegisterTask("cleanup", func(ctx context.Context) error {
+		slog.Info("running cleanup")
+
+		return nil
+	})
+
+	cfg, err := config.Load("config.yaml")
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	aggregator, err := cfg.New()
+	if err != nil {
+		slog.Error("failed to build aggregator", "error", err)
+		os.Exit(1)
+	}
+	aggregator.Start()
+
+	stopped, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// SIGHUP reloads config.yaml.
+	go cfg.ReloadOnSignal(stopped, aggregator, "config.yaml")
+	<-stopped.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	interrupted, err := aggregator.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("shutdown deadline exceeded", "interrupted", interrupted, "error", err)
+	}
+}
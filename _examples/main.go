@@ -3,72 +3,94 @@ package main
 import (
 	"context"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	agg "github.com/kabinasoftware/jobs-agg"
-	"github.com/kabinasoftware/jobs-agg/client/nofluffjobs"
-	"github.com/kabinasoftware/jobs-agg/client/pracuj"
+	"github.com/kabinasoftware/jobs-agg/metrics"
+	"github.com/kabinasoftware/jobs-agg/worker"
+	"github.com/kabinasoftware/jobs-agg/worker/nofluffjobs"
+	"github.com/kabinasoftware/jobs-agg/worker/pracuj"
 )
 
 func main() {
 	var (
-		aggregator = agg.New(3)
-		prw        = pracuj.Init(nil)
-		nfw        = nofluffjobs.Init(nil)
+		aggregator = agg.New(3,
+			agg.WithStore(agg.NewFileStore("jobs-state.json")),
+			agg.WithMetrics(metrics.Default),
+			agg.WithGroupLimit("pracuj.pl", 1),
+			agg.WithGroupLimit("nofluffjobs.com", 1),
+			agg.WithStagger(time.Minute),
+			agg.WithAutoScale(6),
+			// Replicas on the same host share the leases in the temp dir.
+			agg.WithLocker(agg.NewFileLocker(os.TempDir(), ""), 10*time.Minute),
+		)
+		prw = pracuj.Init(nil)
+		nfw = nofluffjobs.Init(nil)
 	)
 
-	aggregator.AddJob("pracuj-scraper", time.Hour, func(ctx context.Context) error {
+	aggregator.AddScheduledJob("pracuj-scraper", agg.MustParseCron("CRON_TZ=Europe/Warsaw 0 6,18 * * *"), func(ctx context.Context) error {
 		slog.Info("scraping pracuj.pl")
 
-		pages, err := prw.GetPagesCount()
-		if err != nil {
-			slog.Error("failed to get pages count", "error", err)
-			return err
-		}
-		if pages == 0 {
-			slog.Info("pages count is equal to 0")
-			return nil
-		}
-
-		for i := 1; i <= pages; i++ {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-				offers, err := prw.GetOffers(i)
-				if err != nil {
-					slog.Error("failed to get offers", "error", err)
-					return err
-				}
-				for _, offer := range offers {
-					slog.Info("offer", "offer", offer)
-				}
+		for offer, err := range worker.Stream(ctx, prw) {
+			if err != nil {
+				slog.Error("failed to get offers", "error", err)
+				return err
 			}
+			slog.Info("offer", "offer", offer)
 		}
 
 		return nil
-	}, time.Now())
+	}, time.Now(), agg.WithRetry(agg.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Minute,
+		MaxDelay:    10 * time.Minute,
+		Jitter:      0.2,
+	}), agg.WithPriority(10), agg.WithTimeout(2*time.Hour), agg.InGroup("pracuj.pl"), agg.WithJitter(5*time.Minute))
 
 	aggregator.AddJob("nofluff-scraper", 30*time.Minute, func(ctx context.Context) error {
 		slog.Info("scraping nofluffjobs")
 		_ = nfw
 		return nil
-	}, time.Now())
+	}, time.Now(), agg.InGroup("nofluffjobs.com"), agg.WithJitterPercent(10))
+
+	for _, source := range []string{"pracuj", "nofluff"} {
+		aggregator.AddJob(source+"-dedup", 0, func(ctx context.Context) error {
+			slog.Info("removing duplicate offers", "source", source)
 
-	aggregator.AddJob("cleanup", 24*time.Hour, func(ctx context.Context) error {
+			return nil
+		}, time.Now(), agg.DependsOn(source+"-scraper"))
+	}
+
+	if err := aggregator.AddJob("cleanup", 0, func(ctx context.Context) error {
 		slog.Info("running cleanup")
 
 		return nil
-	}, time.Now())
+	}, time.Now(), agg.DependsOn("pracuj-scraper", "nofluff-scraper")); err != nil {
+		slog.Error("failed to add cleanup job", "error", err)
+		os.Exit(1)
+	}
 
 	aggregator.Start()
 
+	go func() {
+		if err := http.ListenAndServe(":9090", metrics.Handler()); err != nil {
+			slog.Error("metrics server stopped", "error", err)
+		}
+	}()
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 
-	aggregator.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	interrupted, err := aggregator.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("shutdown deadline exceeded", "interrupted", interrupted, "error", err)
+	}
 }
@@ -2,125 +2,1106 @@ package agg
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/kabinasoftware/jobs-agg/clock"
 )
 
-type Job struct {
-	ID       string
-	Interval time.Duration
-	LastRun  time.Time
-	Execute  func(ctx context.Context) error
-}
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobRunning  = errors.New("job is already running")
+	ErrQueueFull   = errors.New("queue is full")
+	ErrJobTimeout  = errors.New("job timed out")
+)
 
 const (
-	defaultQueueSize  = 100
-	schedulerInterval = 30 * time.Second
+	defaultQueueSize       = 100
+	defaultOverflowTimeout = 5 * time.Second
+	// defaultSchedulerInterval caps how long the scheduler sleeps between
+	// wake-ups and is the back-off for runs that did not fit in the queue.
+	defaultSchedulerInterval = 30 * time.Second
 )
 
 type Aggregator struct {
-	jobs    map[string]*Job
-	queue   chan *Job
-	workers int
-	mu      sync.RWMutex
-	ctx     context.Context
-	cancel  context.CancelFunc
+	jobs     map[string]*Job
+	queue    *runQueue
+	overflow OverflowPolicy
+	// overflowTimeout bounds how long OverflowBlock waits for queue space.
+	overflowTimeout time.Duration
+	overflows       atomic.Uint64
+	// quarantineAfter is the number of consecutive panics after which a
+	// job stops being scheduled. Zero disables quarantine.
+	quarantineAfter int
+	// stagger spaces the first runs of the jobs registered before Start;
+	// staggered counts them and started ends the count. Both are guarded by
+	// mu.
+	stagger   time.Duration
+	staggered int
+	started   bool
+	pool      workerPool
+	// schedulerInterval caps how long the scheduler sleeps between wake-ups
+	// and is the back-off for runs that did not fit in the queue.
+	schedulerInterval time.Duration
+	logger            *slog.Logger
+	// onError is called for every run that failed for good.
+	onError  func(jobID string, err error)
+	store    JobStore
+	locker   Locker
+	leaseTTL time.Duration
+	states   map[string]JobState
+	running  map[*task]struct{}
+	timeline jobHeap
+	wake     chan struct{}
+	history  *history
+	sink     RunSink
+	events   *eventBus
+	metrics  *aggMetrics
+	clock    clock.Clock
+	mu       sync.RWMutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stop     sync.Once
+	wg       sync.WaitGroup
 }
 
-func New(workers int) *Aggregator {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &Aggregator{
-		jobs:    make(map[string]*Job),
-		queue:   make(chan *Job, defaultQueueSize),
-		workers: workers,
-		ctx:     ctx,
-		cancel:  cancel,
+// Option configures an Aggregator created by New.
+type Option func(*Aggregator)
+
+// WithStore persists job state in store so LastRun, the last result and the
+// failure streak survive restarts. A stored LastRun replaces the lastrun a
+// job is added with.
+func WithStore(store JobStore) Option {
+	return func(a *Aggregator) {
+		a.store = store
 	}
 }
 
-func (a *Aggregator) AddJob(id string, interval time.Duration, execute func(ctx context.Context) error, lastrun time.Time) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+// WithOverflow sets what happens to runs dispatched while the queue is full.
+// timeout only applies to OverflowBlock.
+func WithOverflow(policy OverflowPolicy, timeout time.Duration) Option {
+	return func(a *Aggregator) {
+		a.overflow = policy
+		a.overflowTimeout = timeout
+	}
+}
+
+// WithQueueSize sets how many runs may wait for a free worker. The default is
+// 100.
+func WithQueueSize(size int) Option {
+	return func(a *Aggregator) {
+		if size > 0 {
+			a.queue.size = size
+		}
+	}
+}
+
+// WithSchedulerInterval sets the longest time the scheduler sleeps without
+// re-checking the clock, which is also how long a run that did not fit in the
+// queue waits before it is tried again. The default is 30 seconds.
+func WithSchedulerInterval(d time.Duration) Option {
+	return func(a *Aggregator) {
+		if d > 0 {
+			a.schedulerInterval = d
+		}
+	}
+}
+
+// WithLogger sets the logger used by the aggregator instead of slog.Default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *Aggregator) {
+		if logger != nil {
+			a.logger = logger
+		}
+	}
+}
+
+// Logger returns the logger the aggregator reports through.
+func (a *Aggregator) Logger() *slog.Logger {
+	return a.logger
+}
+
+// WithContext derives the context of every job execution from parent.
+// Cancelling parent stops the aggregator like Stop.
+func WithContext(parent context.Context) Option {
+	return func(a *Aggregator) {
+		if parent != nil {
+			a.ctx = parent
+		}
+	}
+}
+
+// WithErrorHandler calls handler for every run that failed for good, that is
+// after its last retry, including timeouts and panics.
+func WithErrorHandler(handler func(jobID string, err error)) Option {
+	return func(a *Aggregator) {
+		a.onError = handler
+	}
+}
+
+// WithStagger postpones the first run of every job registered before Start,
+// after the first one, by a further step, so that jobs due together at
+// startup are spread out. Jobs added once the aggregator runs are not
+// staggered.
+func WithStagger(step time.Duration) Option {
+	return func(a *Aggregator) {
+		a.stagger = step
+	}
+}
 
-	a.jobs[id] = &Job{
+// WithGroupLimit limits how many jobs of a concurrency group run at once.
+// Queued runs of a full group wait without occupying a worker, so jobs in
+// other groups keep running. Groups without a limit are unrestricted.
+func WithGroupLimit(group string, n int) Option {
+	return func(a *Aggregator) {
+		a.queue.setLimit(group, n)
+	}
+}
+
+// WithQuarantine stops scheduling a job after it panicked n times in a row
+// until ResumeJob is called for it.
+func WithQuarantine(n int) Option {
+	return func(a *Aggregator) {
+		a.quarantineAfter = n
+	}
+}
+
+// WithClock replaces the wall clock used for scheduling, retries and run
+// timestamps, typically with a clock.Fake in tests.
+func WithClock(c clock.Clock) Option {
+	return func(a *Aggregator) {
+		a.clock = c
+	}
+}
+
+// WithHistorySize sets how many runs are kept in memory per job. Zero
+// disables the in-memory history.
+func WithHistorySize(size int) Option {
+	return func(a *Aggregator) {
+		a.history = newHistory(size)
+	}
+}
+
+// WithRunSink forwards every recorded JobRun to sink.
+func WithRunSink(sink RunSink) Option {
+	return func(a *Aggregator) {
+		a.sink = sink
+	}
+}
+
+// New creates an aggregator running jobs on the given number of workers.
+func New(workers int, opts ...Option) *Aggregator {
+	a := &Aggregator{
+		jobs:              make(map[string]*Job),
+		queue:             newRunQueue(defaultQueueSize),
+		overflowTimeout:   defaultOverflowTimeout,
+		pool:              workerPool{size: workers, changed: make(chan struct{})},
+		schedulerInterval: defaultSchedulerInterval,
+		logger:            slog.Default(),
+		running:           make(map[*task]struct{}),
+		wake:              make(chan struct{}, 1),
+		history:           newHistory(defaultHistorySize),
+		clock:             clock.Real(),
+		ctx:               context.Background(),
+		done:              make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	a.events = newEventBus(a.logger)
+	a.ctx, a.cancel = context.WithCancel(a.ctx)
+	context.AfterFunc(a.ctx, func() {
+		a.stop.Do(func() { close(a.done) })
+	})
+	// Listeners hear about the runs still finishing after a stop, then their
+	// goroutines exit.
+	go func() {
+		<-a.done
+		a.wg.Wait()
+		a.dropQueued()
+		a.metrics.close()
+		a.events.close()
+	}()
+	return a
+}
+
+// AddJob registers a job that runs every interval. It fails only if the job's
+// dependencies would form a cycle.
+//
+// Adding a job under the ID of a registered one updates that job in place:
+// its runtime state, including LastRun, is kept and executions already
+// queued or running finish with the previous definition.
+func (a *Aggregator) AddJob(id string, interval time.Duration, execute func(ctx context.Context) error, lastrun time.Time, opts ...JobOption) error {
+	return a.addJob(&Job{
 		ID:       id,
 		Interval: interval,
 		Execute:  execute,
 		LastRun:  lastrun,
+	}, opts)
+}
+
+// AddScheduledJob registers a job driven by an arbitrary Schedule, such as one
+// returned by ParseCron.
+func (a *Aggregator) AddScheduledJob(id string, schedule Schedule, execute func(ctx context.Context) error, lastrun time.Time, opts ...JobOption) error {
+	return a.addJob(&Job{
+		ID:       id,
+		Schedule: schedule,
+		Execute:  execute,
+		LastRun:  lastrun,
+	}, opts)
+}
+
+func (a *Aggregator) addJob(job *Job, opts []JobOption) error {
+	for _, opt := range opts {
+		opt(job)
+	}
+	job.index = -1
+	job.chained = make(map[string]uint64, len(job.DependsOn))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.checkCycle(job); err != nil {
+		return fmt.Errorf("failed to add job %q: %w", job.ID, err)
+	}
+	// Only successes from now on count towards the job's dependencies.
+	for _, id := range job.DependsOn {
+		if dep, exists := a.jobs[id]; exists {
+			job.chained[id] = dep.successes
+		}
+	}
+
+	if old, exists := a.jobs[job.ID]; exists {
+		old.update(job)
+		a.reschedule(old)
+		return nil
+	}
+
+	job.offset = job.InitialDelay
+	if !a.started {
+		job.offset += a.stagger * time.Duration(a.staggered)
+		a.staggered++
+	}
+	if state, ok := a.states[job.ID]; ok {
+		job.restore(state)
+		delete(a.states, job.ID)
 	}
+	a.jobs[job.ID] = job
+	a.reschedule(job)
+
+	return nil
 }
 
-func (a *Aggregator) Start() {
-	for i := 0; i < a.workers; i++ {
-		go a.worker()
+// NextRun reports when the job with the given id is due next.
+func (a *Aggregator) NextRun(id string) (time.Time, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	job, exists := a.jobs[id]
+	if !exists {
+		return time.Time{}, false
+	}
+	return a.nextRun(job), true
+}
+
+// nextRun returns when the scheduler will next start job, including offsets
+// and jitter. Callers must hold a.mu.
+func (a *Aggregator) nextRun(job *Job) time.Time {
+	if job.index >= 0 {
+		return job.next
+	}
+	return job.NextRun()
+}
+
+// RemoveJob unregisters a job and drops its history. Runs already queued or in
+// progress are allowed to finish but are not retried.
+func (a *Aggregator) RemoveJob(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	job, exists := a.jobs[id]
+	if !exists {
+		return ErrJobNotFound
+	}
+	job.removed = true
+	job.pending = false
+	job.backlog = nil
+	delete(a.jobs, id)
+	a.scheduleAt(job, time.Time{})
+	a.history.remove(id)
+
+	return nil
+}
+
+// PauseJob stops scheduling a job until ResumeJob is called. A run in
+// progress is not interrupted.
+func (a *Aggregator) PauseJob(id string) error {
+	return a.setPaused(id, true)
+}
+
+// ResumeJob resumes a paused job. It also releases a job from quarantine.
+func (a *Aggregator) ResumeJob(id string) error {
+	return a.setPaused(id, false)
+}
+
+func (a *Aggregator) setPaused(id string, paused bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	job, exists := a.jobs[id]
+	if !exists {
+		return ErrJobNotFound
+	}
+	job.paused = paused
+	if paused {
+		job.backlog = nil
+	} else {
+		job.quarantined = false
+		job.panics = 0
+	}
+	a.reschedule(job)
+
+	return nil
+}
+
+// TriggerNow enqueues an immediate run of a job, paused or not, without
+// changing its schedule. The job's overlap policy still applies.
+func (a *Aggregator) TriggerNow(id string) error {
+	a.mu.RLock()
+	job, exists := a.jobs[id]
+	a.mu.RUnlock()
+	if !exists {
+		return ErrJobNotFound
+	}
+
+	switch a.dispatch(job, TriggerManual) {
+	case dispatchSkipped:
+		return ErrJobRunning
+	case dispatchQueueFull:
+		return ErrQueueFull
 	}
+	return nil
+}
+
+// ListJobs returns a snapshot of every registered job, sorted by ID.
+func (a *Aggregator) ListJobs() []JobInfo {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	infos := make([]JobInfo, 0, len(a.jobs))
+	for _, job := range a.jobs {
+		info := JobInfo{
+			ID:                  job.ID,
+			Status:              StatusIdle,
+			LastRun:             job.LastRun,
+			LastResult:          job.lastResult,
+			LastError:           job.lastError,
+			ConsecutiveFailures: job.failures,
+		}
+		if !job.paused && !job.quarantined {
+			info.NextRun = a.nextRun(job)
+		}
+
+		switch {
+		case slices.ContainsFunc(job.tasks, a.isRunning):
+			info.Status = StatusRunning
+		case len(job.tasks) > 0:
+			info.Status = StatusQueued
+		case job.quarantined:
+			info.Status = StatusQuarantined
+		case job.paused:
+			info.Status = StatusPaused
+		}
+
+		infos = append(infos, info)
+	}
+
+	slices.SortFunc(infos, func(x, y JobInfo) int {
+		return strings.Compare(x.ID, y.ID)
+	})
+	return infos
+}
+
+// History returns the recorded runs of a job, newest first.
+func (a *Aggregator) History(id string) []JobRun {
+	return a.history.list(id)
+}
+
+// LastSuccess returns the most recent successful run of a job still held in
+// the history.
+func (a *Aggregator) LastSuccess(id string) (JobRun, bool) {
+	for _, run := range a.history.list(id) {
+		if run.Result == ResultSucceeded {
+			return run, true
+		}
+	}
+	return JobRun{}, false
+}
+
+// Subscribe registers l for lifecycle events and returns a function that
+// removes it again. Every subscription is closed once the aggregator has
+// stopped and its workers have exited.
+func (a *Aggregator) Subscribe(l Listener) (unsubscribe func()) {
+	return a.events.subscribe(l)
+}
+
+// emit records event in the metrics and hands it to the listeners.
+func (a *Aggregator) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = a.clock.Now()
+	}
+	a.metrics.observe(event)
+	a.events.publish(event)
+}
+
+// Overflows returns how many runs were dropped or evicted because the queue
+// was full.
+func (a *Aggregator) Overflows() uint64 {
+	return a.overflows.Load()
+}
+
+func (a *Aggregator) isRunning(t *task) bool {
+	_, ok := a.running[t]
+	return ok
+}
+
+func (a *Aggregator) Start() {
+	a.loadStates()
+
+	a.mu.Lock()
+	a.started = true
+	a.mu.Unlock()
+
+	a.pool.mu.Lock()
+	a.pool.started = true
+	a.resize(a.pool.size)
+	a.pool.mu.Unlock()
+
+	a.wg.Add(1)
 	go a.scheduler()
 }
 
+// Stop stops scheduling and cancels running jobs without waiting for them.
 func (a *Aggregator) Stop() {
+	a.stop.Do(func() { close(a.done) })
 	a.cancel()
-	close(a.queue)
+}
+
+// Shutdown stops scheduling new runs and waits for running jobs to finish. If
+// ctx expires first, the jobs still running are cancelled and their IDs are
+// returned together with ctx's error; their runs are recorded as
+// ResultInterrupted. Runs still queued are dropped. Shutdown always waits for
+// every worker goroutine to exit before returning, then releases the jobs'
+// leases.
+func (a *Aggregator) Shutdown(ctx context.Context) (interrupted []string, err error) {
+	a.stop.Do(func() { close(a.done) })
+
+	finished := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		a.cancel()
+		a.dropQueued()
+		a.releaseLeases()
+		return nil, nil
+	case <-ctx.Done():
+	}
+
+	interrupted = a.runningJobs()
+	a.cancel()
+	<-finished
+	a.dropQueued()
+	a.releaseLeases()
+
+	return interrupted, ctx.Err()
+}
+
+func (a *Aggregator) runningJobs() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	ids := make([]string, 0, len(a.running))
+	for t := range a.running {
+		ids = append(ids, t.job.ID)
+	}
+	return ids
+}
+
+// dropQueued releases the runs left in the queue once the workers have
+// exited.
+func (a *Aggregator) dropQueued() {
+	for _, t := range a.queue.drain() {
+		a.drop(t)
+	}
+}
+
+// drop releases a queued run that will not start because the aggregator is
+// stopping.
+func (a *Aggregator) drop(t *task) {
+	a.emit(Event{
+		Type:    EventJobSkipped,
+		JobID:   t.job.ID,
+		Attempt: t.attempt,
+		Trigger: t.triggerReason(),
+		Reason:  SkipShutdown,
+	})
+	a.finish(t)
+}
+
+func (a *Aggregator) overlapOf(job *Job) OverlapPolicy {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return job.Overlap
+}
+
+func (a *Aggregator) stopping() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (a *Aggregator) worker() {
+	defer a.wg.Done()
+
 	for {
-		select {
-		case <-a.ctx.Done():
+		if a.retire(false) {
 			return
-		case job := <-a.queue:
-			if err := job.Execute(a.ctx); err != nil {
-				slog.Error("job execution failed",
-					"id", job.ID,
-					"error", err)
-			} else {
-				slog.Info("job completed successfully", "id", job.ID)
+		}
+		t, ok := a.queue.pop()
+		if !ok {
+			// changed is fetched first so that a resize made after the
+			// check below still wakes the worker.
+			changed := a.poolChanged()
+			if a.retire(true) {
+				return
 			}
+			select {
+			case <-a.done:
+				return
+			case <-a.queue.ready:
+			case <-changed:
+			}
+			continue
+		}
+		if a.stopping() {
+			a.queue.release(t)
+			a.drop(t)
+			return
 		}
+		a.setBusy(1)
+		a.execute(t)
+		a.setBusy(-1)
 	}
 }
 
-func (a *Aggregator) scheduler() {
-	ticker := time.NewTicker(schedulerInterval)
-	defer ticker.Stop()
+func (a *Aggregator) loadStates() {
+	if a.store == nil {
+		return
+	}
 
-	for {
-		select {
-		case <-a.ctx.Done():
-			return
-		case <-ticker.C:
-			now := time.Now()
-			jobs := a.getJobsToRun(now)
-
-			for _, job := range jobs {
-				select {
-				case a.queue <- job:
-					a.updateJobLastRun(job.ID, now)
-				default:
-					slog.Warn("queue is full, skipping job", "id", job.ID)
-				}
+	states, err := a.store.Load(a.ctx)
+	if err != nil {
+		a.logger.Error("failed to load job states", "error", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// States of jobs added later are applied by addJob; each state is only
+	// applied once so a job removed and added again keeps its newer LastRun.
+	a.states = states
+	for id, state := range states {
+		if job, exists := a.jobs[id]; exists {
+			job.restore(state)
+			a.reschedule(job)
+			delete(a.states, id)
+		}
+	}
+}
+
+func (a *Aggregator) saveState(job *Job, result RunResult, err error) {
+	quarantined := false
+	a.mu.Lock()
+	job.lastResult = result
+	// A run cut short neither breaks nor extends the failure streak.
+	if !result.cutShort() {
+		if result == ResultPanicked {
+			job.panics++
+			if a.quarantineAfter > 0 && job.panics >= a.quarantineAfter && !job.quarantined {
+				job.quarantined = true
+				quarantined = true
+				a.reschedule(job)
 			}
+		} else {
+			job.panics = 0
+		}
+		if err != nil {
+			job.lastError = err.Error()
+			job.failures++
+		} else {
+			job.lastError = ""
+			job.failures = 0
 		}
 	}
+	state := job.state()
+	a.mu.Unlock()
+
+	if quarantined {
+		a.logger.Error("job quarantined after repeated panics", "id", job.ID, "panics", a.quarantineAfter)
+		a.emit(Event{Type: EventJobQuarantined, JobID: job.ID, Err: err})
+	}
+
+	if a.store == nil {
+		return
+	}
+	if err := a.store.Save(context.WithoutCancel(a.ctx), state); err != nil {
+		a.logger.Error("failed to save job state", "id", job.ID, "error", err)
+	}
+}
+
+type dispatchResult int
+
+const (
+	dispatched dispatchResult = iota
+	dispatchSkipped
+	dispatchPending
+	dispatchQueueFull
+	dispatchMisfired
+)
+
+// dispatch enqueues a new execution of job, honouring its overlap policy.
+func (a *Aggregator) dispatch(job *Job, trigger TriggerReason) dispatchResult {
+	return a.dispatchAt(job, trigger, time.Time{}, nil)
 }
 
-func (a *Aggregator) getJobsToRun(now time.Time) []*Job {
+// dispatchAt is like dispatch for a run of the schedule slot scheduled that
+// also stands in for the missed slots.
+func (a *Aggregator) dispatchAt(job *Job, trigger TriggerReason, scheduled time.Time, missed []time.Time) dispatchResult {
 	a.mu.Lock()
-	defer a.mu.Unlock()
+	if len(job.tasks) > 0 {
+		switch job.Overlap {
+		case OverlapQueue:
+			job.pending = true
+			a.mu.Unlock()
+			return dispatchPending
+		case OverlapCancelPrevious:
+		default:
+			a.mu.Unlock()
+			a.emit(Event{
+				Type:    EventJobSkipped,
+				JobID:   job.ID,
+				Trigger: trigger,
+				Reason:  SkipStillRunning,
+			})
+			return dispatchSkipped
+		}
+	}
 
-	var jobs []*Job
-	for _, job := range a.jobs {
-		if now.Sub(job.LastRun) >= job.Interval {
-			jobs = append(jobs, job)
+	t := &task{
+		job:       job,
+		attempt:   1,
+		trigger:   trigger,
+		priority:  job.Priority,
+		group:     job.Group,
+		execute:   job.Execute,
+		timeout:   job.Timeout,
+		retry:     job.Retry,
+		scheduled: scheduled,
+		missed:    missed,
+		after:     append([]*task(nil), job.tasks...),
+		done:      make(chan struct{}),
+		index:     -1,
+	}
+	// The task is registered before it is queued so that concurrent
+	// dispatches already see the job as busy.
+	job.tasks = append(job.tasks, t)
+	a.mu.Unlock()
+
+	if !a.enqueue(t) {
+		a.finish(t)
+		return dispatchQueueFull
+	}
+	a.emit(Event{Type: EventJobScheduled, JobID: job.ID, Trigger: trigger, Attempt: 1})
+
+	a.mu.Lock()
+	for _, prev := range t.after {
+		prev.cancelled = true
+		if prev.cancel != nil {
+			prev.cancel()
 		}
 	}
-	return jobs
+	a.mu.Unlock()
+
+	return dispatched
 }
 
-func (a *Aggregator) updateJobLastRun(id string, now time.Time) {
+// enqueue pushes t onto the queue according to the overflow policy and
+// reports whether it was accepted.
+func (a *Aggregator) enqueue(t *task) bool {
+	evicted, err := a.queue.push(t, a.overflow, a.overflowTimeout, a.clock, a.done)
+	if evicted != nil {
+		a.overflows.Add(1)
+		a.logger.Warn("queue is full, evicting lower priority run",
+			"id", evicted.job.ID,
+			"priority", evicted.priority,
+			"replaced_by", t.job.ID)
+		a.emit(Event{
+			Type:    EventQueueFull,
+			JobID:   evicted.job.ID,
+			Attempt: evicted.attempt,
+			Trigger: evicted.triggerReason(),
+			Reason:  OverflowEvicted,
+		})
+		a.finish(evicted)
+	}
+
+	if err == nil {
+		a.scaleUp()
+		return true
+	}
+	if errors.Is(err, errQueueClosed) {
+		return false
+	}
+
+	a.overflows.Add(1)
+	reason := OverflowDropped
+	if errors.Is(err, errQueueTimeout) {
+		reason = OverflowTimedOut
+	}
+	a.emit(Event{
+		Type:    EventQueueFull,
+		JobID:   t.job.ID,
+		Attempt: t.attempt,
+		Trigger: t.triggerReason(),
+		Reason:  reason,
+	})
+	return false
+}
+
+// finish releases t once it will not be attempted again and starts the job's
+// pending run, if any.
+func (a *Aggregator) finish(t *task) {
+	job := t.job
+
 	a.mu.Lock()
-	defer a.mu.Unlock()
-	if job, exists := a.jobs[id]; exists {
-		job.LastRun = now
+	for i, other := range job.tasks {
+		if other == t {
+			job.tasks = append(job.tasks[:i], job.tasks[i+1:]...)
+			break
+		}
+	}
+	close(t.done)
+
+	idle := len(job.tasks) == 0 && !job.removed && !a.stopping()
+	var (
+		catchUp time.Time
+		pending bool
+	)
+	switch {
+	case idle && len(job.backlog) > 0:
+		catchUp = job.backlog[0]
+		job.backlog = job.backlog[1:]
+	case idle && job.pending:
+		job.pending = false
+		pending = true
+	}
+	a.mu.Unlock()
+
+	if !catchUp.IsZero() {
+		if a.dispatchAt(job, TriggerCatchUp, catchUp, nil) == dispatchQueueFull {
+			a.logger.Warn("queue is full, dropping catch-up run", "id", job.ID, "scheduled", catchUp)
+		}
+	}
+	if pending {
+		if a.dispatch(job, TriggerPending) == dispatchQueueFull {
+			a.logger.Warn("queue is full, dropping pending run", "id", job.ID)
+		}
+	}
+}
+
+func (a *Aggregator) execute(t *task) {
+	job := t.job
+	defer a.queue.release(t)
+
+	for _, prev := range t.after {
+		select {
+		case <-prev.done:
+		case <-a.ctx.Done():
+		}
+	}
+
+	if !a.acquireLease(job) {
+		a.logger.Info("job lease held elsewhere, skipping run", "id", job.ID)
+		a.emit(Event{
+			Type:    EventJobSkipped,
+			JobID:   job.ID,
+			Attempt: t.attempt,
+			Trigger: t.triggerReason(),
+			Reason:  SkipNotLeader,
+		})
+		a.finish(t)
+		return
+	}
+
+	a.mu.Lock()
+	if t.cancelled {
+		a.mu.Unlock()
+		a.emit(Event{
+			Type:    EventJobSkipped,
+			JobID:   job.ID,
+			Attempt: t.attempt,
+			Trigger: t.triggerReason(),
+			Reason:  SkipCancelled,
+		})
+		a.finish(t)
+		return
+	}
+	ctx, cancel := context.WithCancel(a.ctx)
+	t.cancel = cancel
+	a.running[t] = struct{}{}
+	a.mu.Unlock()
+
+	if t.timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, t.timeout, ErrJobTimeout)
+		defer cancelTimeout()
+	}
+	var stopRenewal func()
+	if a.locker != nil {
+		var cancelLease context.CancelCauseFunc
+		ctx, cancelLease = context.WithCancelCause(ctx)
+		defer cancelLease(nil)
+		stopRenewal = a.renewLease(job, cancelLease)
+	}
+
+	start := a.clock.Now()
+	a.emit(Event{
+		Type:    EventJobStarted,
+		JobID:   job.ID,
+		Time:    start,
+		Attempt: t.attempt,
+		Trigger: t.triggerReason(),
+	})
+	err := safeExecute(withSchedule(withAttempt(ctx, t.attempt), t), t.execute)
+	end := a.clock.Now()
+	// The lease is extended before the run is reported.
+	if stopRenewal != nil {
+		stopRenewal()
+		a.holdLease(job)
+	}
+
+	var panicErr *PanicError
+	result := ResultSucceeded
+	if err != nil {
+		result = ResultFailed
+		if errors.As(err, &panicErr) {
+			result = ResultPanicked
+		} else if errors.Is(context.Cause(ctx), ErrJobTimeout) {
+			result = ResultTimedOut
+			err = fmt.Errorf("%w after %s: %w", ErrJobTimeout, t.timeout, err)
+		} else if errors.Is(context.Cause(ctx), ErrLeaseLost) {
+			err = fmt.Errorf("%w: %w", ErrLeaseLost, err)
+		} else if a.ctx.Err() != nil {
+			result = ResultInterrupted
+		} else if ctx.Err() != nil {
+			result = ResultCancelled
+		}
+	}
+	cancel()
+
+	a.mu.Lock()
+	delete(a.running, t)
+	t.cancel = nil
+	cancelled := t.cancelled || job.removed
+	a.mu.Unlock()
+
+	a.saveState(job, result, err)
+	a.record(t, start, end, result, err)
+
+	if panicErr != nil {
+		a.logger.Error("job panicked",
+			"id", job.ID,
+			"attempt", t.attempt,
+			"panic", panicErr.Value,
+			"stack", string(panicErr.Stack))
+		a.fail(job, err)
+		a.finish(t)
+		return
+	}
+	if result.cutShort() {
+		msg := "job cancelled by a newer run"
+		if result == ResultInterrupted {
+			msg = "job interrupted by shutdown"
+		}
+		a.logger.Warn(msg, "id", job.ID, "attempt", t.attempt, "error", err)
+		a.finish(t)
+		return
+	}
+	if err == nil {
+		a.logger.Info("job completed successfully", "id", job.ID, "attempt", t.attempt, "duration", end.Sub(start))
+		a.finish(t)
+		if !cancelled && !a.stopping() {
+			a.chain(job)
+		}
+		return
+	}
+
+	if !cancelled && !a.stopping() && a.ctx.Err() == nil && t.retry.shouldRetry(t.attempt, err) {
+		delay := t.retry.delay(t.attempt)
+		a.logger.Warn("job execution failed, retrying",
+			"id", job.ID,
+			"attempt", t.attempt,
+			"retry_in", delay,
+			"result", result,
+			"error", err)
+		t.attempt++
+		a.clock.AfterFunc(delay, func() {
+			a.retry(t)
+		})
+		return
+	}
+
+	msg := "job execution failed"
+	if result == ResultTimedOut {
+		msg = "job execution timed out"
+	}
+	a.logger.Error(msg,
+		"id", job.ID,
+		"attempt", t.attempt,
+		"timeout", t.timeout,
+		"error", err)
+	a.fail(job, err)
+	a.finish(t)
+}
+
+// fail hands a run that failed for good to the error handler.
+func (a *Aggregator) fail(job *Job, err error) {
+	if a.onError != nil {
+		a.onError(job.ID, err)
+	}
+}
+
+func (a *Aggregator) record(t *task, start, end time.Time, result RunResult, err error) {
+	run := JobRun{
+		JobID:    t.job.ID,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start),
+		Result:   result,
+		Err:      err,
+		Attempt:  t.attempt,
+		Trigger:  t.triggerReason(),
+	}
+
+	// Runs finishing after their job was removed are not kept.
+	a.mu.RLock()
+	if !t.job.removed {
+		a.history.add(run)
+	}
+	a.mu.RUnlock()
+	if a.sink != nil {
+		a.sink.Record(run)
+	}
+
+	event := Event{
+		Type:    EventJobSucceeded,
+		JobID:   run.JobID,
+		Time:    end,
+		Attempt: run.Attempt,
+		Trigger: run.Trigger,
+		Err:     err,
+		Run:     &run,
+	}
+	switch {
+	case result.cutShort():
+		event.Type = EventJobCancelled
+	case err != nil:
+		event.Type = EventJobFailed
+	}
+	a.emit(event)
+}
+
+func (a *Aggregator) retry(t *task) {
+	a.mu.RLock()
+	dropped := t.job.removed || t.cancelled
+	a.mu.RUnlock()
+	if dropped || a.stopping() {
+		a.finish(t)
+		return
+	}
+	if !a.enqueue(t) {
+		a.logger.Warn("queue is full, dropping retry", "id", t.job.ID, "attempt", t.attempt)
+		a.finish(t)
+		return
+	}
+	a.emit(Event{
+		Type:    EventJobScheduled,
+		JobID:   t.job.ID,
+		Attempt: t.attempt,
+		Trigger: TriggerRetry,
+	})
+}
+
+func (a *Aggregator) scheduler() {
+	defer a.wg.Done()
+
+	a.drainWake()
+	timer := a.clock.NewTimer(a.untilNext(a.clock.Now()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-a.wake:
+		case <-timer.C():
+		}
+
+		now := a.clock.Now()
+		for _, job := range a.dueJobs(now) {
+			switch a.dispatchDue(job, now) {
+			case dispatchQueueFull:
+				a.logger.Warn("queue is full, skipping job", "id", job.ID)
+				a.mu.Lock()
+				a.scheduleAt(job, now.Add(a.schedulerInterval))
+				a.mu.Unlock()
+				continue
+			case dispatchSkipped:
+				a.logger.Warn("job still running, skipping run", "id", job.ID, "overlap", a.overlapOf(job).String())
+			case dispatchPending:
+				a.logger.Info("job still running, run queued", "id", job.ID, "overlap", a.overlapOf(job).String())
+			case dispatchMisfired:
+				a.logger.Warn("job misfired, skipping to next slot", "id", job.ID)
+			}
+
+			a.mu.Lock()
+			// The jitter only delays this run; later runs keep to the
+			// schedule instead of drifting by it.
+			job.LastRun = now.Add(-job.jitter)
+			job.offset = 0
+			a.reschedule(job)
+			a.mu.Unlock()
+		}
+
+		// Changes made before the drain are reflected in untilNext; later
+		// ones leave a fresh wake-up behind.
+		a.drainWake()
+		timer.Reset(a.untilNext(a.clock.Now()))
 	}
 }
@@ -0,0 +1,1037 @@
+package agg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kabinasoftware/jobs-agg/clock"
+)
+
+var testEpoch = time.Date(2025, time.January, 6, 12, 0, 0, 0, time.UTC)
+
+func newTestAggregator(t *testing.T, workers int, opts ...Option) (*Aggregator, *clock.Fake) {
+	t.Helper()
+
+	fake := clock.NewFake(testEpoch)
+	a := New(workers, append([]Option{WithClock(fake)}, opts...)...)
+	t.Cleanup(a.Stop)
+
+	return a, fake
+}
+
+func subscribe(a *Aggregator, types ...EventType) <-chan Event {
+	events := make(chan Event, 100)
+	a.Subscribe(ListenerFunc(func(e Event) {
+		if slices.Contains(types, e.Type) {
+			events <- e
+		}
+	}))
+	return events
+}
+
+func waitEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return Event{}
+	}
+}
+
+func jobInfo(t *testing.T, a *Aggregator, id string) JobInfo {
+	t.Helper()
+
+	for _, info := range a.ListJobs() {
+		if info.ID == id {
+			return info
+		}
+	}
+	t.Fatalf("job %q not found", id)
+	return JobInfo{}
+}
+
+func noop(context.Context) error {
+	return nil
+}
+
+func TestDueJobs(t *testing.T) {
+	a, _ := newTestAggregator(t, 0)
+	now := testEpoch
+
+	a.AddJob("due", time.Minute, noop, now.Add(-time.Minute))
+	a.AddJob("overdue", time.Minute, noop, now.Add(-time.Hour))
+	a.AddJob("not-due", time.Hour, noop, now.Add(-time.Minute))
+	a.AddJob("no-interval", 0, noop, now.Add(-time.Hour))
+	a.AddJob("paused", time.Minute, noop, now.Add(-time.Hour))
+	a.AddScheduledJob("cron-due", MustParseCron("0 12 * * *"), noop, now.Add(-time.Hour))
+	a.AddScheduledJob("cron-not-due", MustParseCron("0 13 * * *"), noop, now.Add(-time.Hour))
+	if err := a.PauseJob("paused"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, job := range a.dueJobs(now) {
+		got = append(got, job.ID)
+	}
+	slices.Sort(got)
+
+	want := []string{"cron-due", "due", "overdue"}
+	if !slices.Equal(got, want) {
+		t.Errorf("dueJobs() = %v, want %v", got, want)
+	}
+	if got := a.dueJobs(now); len(got) != 0 {
+		t.Errorf("dueJobs() returned %d jobs twice", len(got))
+	}
+}
+
+func TestSchedulerRunsDueJobAndUpdatesLastRun(t *testing.T) {
+	a, _ := newTestAggregator(t, 1)
+
+	ran := make(chan struct{}, 1)
+	a.AddJob("scrape", time.Minute, func(context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}, testEpoch.Add(-time.Minute))
+	a.AddJob("later", time.Hour, noop, testEpoch)
+	succeeded := subscribe(a, EventJobSucceeded)
+
+	a.Start()
+
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatal("due job was not executed")
+	}
+	waitEvent(t, succeeded)
+
+	tick := testEpoch
+	if got := jobInfo(t, a, "scrape").LastRun; !got.Equal(tick) {
+		t.Errorf("LastRun = %v, want %v", got, tick)
+	}
+	if got := jobInfo(t, a, "scrape").NextRun; !got.Equal(tick.Add(time.Minute)) {
+		t.Errorf("NextRun = %v, want %v", got, tick.Add(time.Minute))
+	}
+	if got := jobInfo(t, a, "later").LastRun; !got.Equal(testEpoch) {
+		t.Errorf("LastRun of job not due = %v, want %v", got, testEpoch)
+	}
+}
+
+func TestSchedulerQueueFullKeepsLastRun(t *testing.T) {
+	a, fake := newTestAggregator(t, 0)
+	a.queue = newRunQueue(1)
+
+	lastRun := testEpoch.Add(-time.Hour)
+	a.AddJob("first", time.Minute, noop, lastRun)
+	a.AddJob("second", time.Minute, noop, lastRun)
+	full := subscribe(a, EventQueueFull)
+
+	a.Start()
+	dropped := waitEvent(t, full)
+
+	queued := "first"
+	if dropped.JobID == "first" {
+		queued = "second"
+	}
+	tick := testEpoch
+	if got := jobInfo(t, a, queued).LastRun; !got.Equal(tick) {
+		t.Errorf("LastRun of queued job = %v, want %v", got, tick)
+	}
+	if got := jobInfo(t, a, dropped.JobID).LastRun; !got.Equal(lastRun) {
+		t.Errorf("LastRun of dropped job = %v, want unchanged %v", got, lastRun)
+	}
+	if got := jobInfo(t, a, queued).Status; got != StatusQueued {
+		t.Errorf("Status of queued job = %q, want %q", got, StatusQueued)
+	}
+
+	// The dropped job stays due and is offered again after the scheduler interval.
+	fake.BlockUntil(1)
+	fake.Advance(defaultSchedulerInterval)
+	if e := waitEvent(t, full); e.JobID != dropped.JobID {
+		t.Errorf("QueueFull on second tick for %q, want %q", e.JobID, dropped.JobID)
+	}
+}
+
+func TestSchedulerSkipsJobStillRunning(t *testing.T) {
+	a, fake := newTestAggregator(t, 2)
+
+	release := make(chan struct{})
+	a.AddJob("slow", time.Second, func(context.Context) error {
+		<-release
+		return nil
+	}, testEpoch.Add(-time.Hour))
+	events := subscribe(a, EventJobStarted, EventJobSkipped)
+	defer close(release)
+
+	a.Start()
+	if e := waitEvent(t, events); e.Type != EventJobStarted {
+		t.Fatalf("got %s, want %s", e.Type, EventJobStarted)
+	}
+
+	fake.Advance(time.Second)
+	e := waitEvent(t, events)
+	if e.Type != EventJobSkipped || e.Reason != SkipStillRunning {
+		t.Fatalf("got %s (%s), want %s (%s)", e.Type, e.Reason, EventJobSkipped, SkipStillRunning)
+	}
+
+	// Skipped runs still advance LastRun so the job is not offered every tick.
+	tick := testEpoch.Add(time.Second)
+	if got := jobInfo(t, a, "slow").LastRun; !got.Equal(tick) {
+		t.Errorf("LastRun = %v, want %v", got, tick)
+	}
+}
+
+func TestSchedulerWakesExactlyWhenJobIsDue(t *testing.T) {
+	a, fake := newTestAggregator(t, 1)
+	a.AddJob("soon", 10*time.Second, noop, testEpoch)
+	started := subscribe(a, EventJobStarted)
+
+	a.Start()
+	fake.BlockUntil(1)
+	fake.Advance(10*time.Second - time.Millisecond)
+	select {
+	case <-started:
+		t.Fatal("job started before it was due")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fake.Advance(time.Millisecond)
+	if e := waitEvent(t, started); !e.Time.Equal(testEpoch.Add(10 * time.Second)) {
+		t.Errorf("job started at %v, want %v", e.Time, testEpoch.Add(10*time.Second))
+	}
+}
+
+func TestRetryWaitsForBackoff(t *testing.T) {
+	a, fake := newTestAggregator(t, 1)
+
+	attempts := make(chan int, 3)
+	a.AddJob("flaky", time.Hour, func(ctx context.Context) error {
+		attempts <- Attempt(ctx)
+		if Attempt(ctx) == 1 {
+			return errors.New("503 Service Unavailable")
+		}
+		return nil
+	}, testEpoch, WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute}))
+	failed := subscribe(a, EventJobFailed)
+	succeeded := subscribe(a, EventJobSucceeded)
+
+	a.Start()
+	fake.BlockUntil(1)
+	if err := a.TriggerNow("flaky"); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, failed)
+	<-attempts
+	fake.BlockUntil(2)
+
+	fake.Advance(time.Minute - time.Second)
+	select {
+	case n := <-attempts:
+		t.Fatalf("attempt %d started before backoff elapsed", n)
+	default:
+	}
+
+	fake.Advance(time.Second)
+	e := waitEvent(t, succeeded)
+	if e.Attempt != 2 || e.Trigger != TriggerRetry {
+		t.Errorf("succeeded with attempt %d trigger %q, want 2 %q", e.Attempt, e.Trigger, TriggerRetry)
+	}
+	if got := len(a.History("flaky")); got != 2 {
+		t.Errorf("len(History) = %d, want 2", got)
+	}
+}
+
+func TestRemoveJobDropsPendingRetry(t *testing.T) {
+	a, fake := newTestAggregator(t, 1)
+
+	attempts := make(chan int, 3)
+	a.AddJob("flaky", time.Hour, func(ctx context.Context) error {
+		attempts <- Attempt(ctx)
+		return errors.New("503 Service Unavailable")
+	}, testEpoch, WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute}))
+	failed := subscribe(a, EventJobFailed)
+
+	a.Start()
+	fake.BlockUntil(1)
+	if err := a.TriggerNow("flaky"); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, failed)
+	<-attempts
+	fake.BlockUntil(2)
+
+	if err := a.RemoveJob("flaky"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RemoveJob("flaky"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("second RemoveJob() = %v, want %v", err, ErrJobNotFound)
+	}
+	fake.Advance(time.Minute)
+
+	select {
+	case n := <-attempts:
+		t.Errorf("attempt %d ran after the job was removed", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestRetryDelayDoesNotOverflow(t *testing.T) {
+	var prev time.Duration
+	steady := &RetryPolicy{BaseDelay: time.Second}
+	jittered := &RetryPolicy{BaseDelay: time.Second, Jitter: 1}
+	for attempt := 1; attempt <= 100; attempt++ {
+		d := steady.delay(attempt)
+		if d < prev {
+			t.Fatalf("delay(%d) = %v, want at least %v", attempt, d, prev)
+		}
+		prev = d
+		if d := jittered.delay(attempt); d < 0 {
+			t.Fatalf("jittered delay(%d) = %v, want positive", attempt, d)
+		}
+	}
+}
+
+func TestTimeoutIsReportedAsDistinctFailure(t *testing.T) {
+	a, _ := newTestAggregator(t, 1)
+
+	a.AddJob("hung", time.Hour, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, testEpoch, WithTimeout(10*time.Millisecond))
+	failed := subscribe(a, EventJobFailed)
+
+	a.Start()
+	if err := a.TriggerNow("hung"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := waitEvent(t, failed)
+	if e.Run.Result != ResultTimedOut {
+		t.Errorf("Result = %q, want %q", e.Run.Result, ResultTimedOut)
+	}
+	if !errors.Is(e.Err, ErrJobTimeout) || !errors.Is(e.Err, context.DeadlineExceeded) {
+		t.Errorf("Err = %v, want it to wrap ErrJobTimeout and the job's error", e.Err)
+	}
+	if got := jobInfo(t, a, "hung").LastResult; got != ResultTimedOut {
+		t.Errorf("LastResult = %q, want %q", got, ResultTimedOut)
+	}
+}
+
+func TestPanicIsRecoveredAndQuarantinesJob(t *testing.T) {
+	a, _ := newTestAggregator(t, 1, WithQuarantine(2))
+
+	a.AddJob("broken", time.Hour, func(context.Context) error {
+		panic("boom")
+	}, testEpoch)
+	failed := subscribe(a, EventJobFailed)
+	quarantined := subscribe(a, EventJobQuarantined)
+
+	a.Start()
+	for i := 0; i < 2; i++ {
+		if err := a.TriggerNow("broken"); err != nil {
+			t.Fatal(err)
+		}
+		e := waitEvent(t, failed)
+		var panicErr *PanicError
+		if !errors.As(e.Err, &panicErr) || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+			t.Fatalf("Err = %v, want a PanicError with stack", e.Err)
+		}
+		if e.Run.Result != ResultPanicked {
+			t.Errorf("Result = %q, want %q", e.Run.Result, ResultPanicked)
+		}
+	}
+
+	waitEvent(t, quarantined)
+	info := jobInfo(t, a, "broken")
+	if info.Status != StatusQuarantined || !info.NextRun.IsZero() {
+		t.Errorf("Status = %q, NextRun = %v, want quarantined with no next run", info.Status, info.NextRun)
+	}
+
+	if err := a.ResumeJob("broken"); err != nil {
+		t.Fatal(err)
+	}
+	if info := jobInfo(t, a, "broken"); info.Status == StatusQuarantined {
+		t.Error("job still quarantined after ResumeJob")
+	}
+}
+
+func TestDependentRunsAfterAllDependenciesSucceed(t *testing.T) {
+	a, _ := newTestAggregator(t, 2)
+
+	a.AddJob("a", time.Hour, noop, testEpoch)
+	a.AddJob("b", time.Hour, noop, testEpoch)
+	if err := a.AddJob("c", 0, noop, testEpoch, DependsOn("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	succeeded := subscribe(a, EventJobSucceeded)
+
+	a.Start()
+	if err := a.TriggerNow("a"); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitEvent(t, succeeded); e.JobID != "a" {
+		t.Fatalf("JobID = %q, want a", e.JobID)
+	}
+	if err := a.TriggerNow("b"); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitEvent(t, succeeded); e.JobID != "b" {
+		t.Fatalf("JobID = %q, want b", e.JobID)
+	}
+
+	e := waitEvent(t, succeeded)
+	if e.JobID != "c" || e.Trigger != TriggerDependency {
+		t.Errorf("got %s run of %q, want dependency run of c", e.Trigger, e.JobID)
+	}
+
+	// A second success of a alone must not start c again.
+	if err := a.TriggerNow("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, succeeded)
+	select {
+	case e := <-succeeded:
+		t.Errorf("unexpected run of %q", e.JobID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAddJobRejectsDependencyCycle(t *testing.T) {
+	a, _ := newTestAggregator(t, 0)
+
+	if err := a.AddJob("a", 0, noop, testEpoch, DependsOn("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddJob("b", 0, noop, testEpoch, DependsOn("a")); err != nil {
+		t.Fatal(err)
+	}
+	err := a.AddJob("c", 0, noop, testEpoch, DependsOn("b"))
+	if !errors.Is(err, ErrDependencyCycle) {
+		t.Fatalf("AddJob() error = %v, want ErrDependencyCycle", err)
+	}
+	if _, exists := a.NextRun("c"); exists {
+		t.Error("job closing the cycle was registered")
+	}
+	if err := a.AddJob("self", 0, noop, testEpoch, DependsOn("self")); !errors.Is(err, ErrDependencyCycle) {
+		t.Errorf("AddJob() error = %v, want ErrDependencyCycle", err)
+	}
+}
+
+func TestJitterDelaysRunWithinBound(t *testing.T) {
+	a, _ := newTestAggregator(t, 0)
+
+	a.AddJob("fixed", time.Hour, noop, testEpoch, WithJitter(time.Minute))
+	a.AddJob("percent", time.Hour, noop, testEpoch, WithJitterPercent(10))
+
+	for id, limit := range map[string]time.Duration{"fixed": time.Minute, "percent": 6 * time.Minute} {
+		next, _ := a.NextRun(id)
+		due := testEpoch.Add(time.Hour)
+		if next.Before(due) || !next.Before(due.Add(limit)) {
+			t.Errorf("%s: NextRun = %v, want within [%v, %v)", id, next, due, due.Add(limit))
+		}
+		// Rescheduling keeps the delay drawn for the same run.
+		if err := a.PauseJob(id); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.ResumeJob(id); err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := a.NextRun(id); !again.Equal(next) {
+			t.Errorf("%s: NextRun moved from %v to %v after resume", id, next, again)
+		}
+	}
+}
+
+func TestJitterDoesNotDriftSchedule(t *testing.T) {
+	a, fake := newTestAggregator(t, 1)
+
+	ran := subscribe(a, EventJobSucceeded)
+	a.AddJob("scrape", time.Hour, noop, testEpoch, WithJitter(30*time.Minute))
+	a.Start()
+
+	for i := 1; i <= 6; i++ {
+		next, _ := a.NextRun("scrape")
+		slot := testEpoch.Add(time.Duration(i) * time.Hour)
+		if next.Before(slot) || !next.Before(slot.Add(30*time.Minute)) {
+			t.Fatalf("run %d: NextRun = %v, want within [%v, %v)", i, next, slot, slot.Add(30*time.Minute))
+		}
+		fake.Set(next)
+		waitEvent(t, ran)
+		// The scheduler re-arms its timer once LastRun is updated.
+		fake.BlockUntil(1)
+		if got := jobInfo(t, a, "scrape").LastRun; !got.Equal(slot) {
+			t.Fatalf("run %d: LastRun = %v, want %v", i, got, slot)
+		}
+	}
+}
+
+func TestStaggerSpreadsOverdueJobs(t *testing.T) {
+	a, _ := newTestAggregator(t, 0, WithStagger(time.Minute))
+
+	a.AddJob("first", time.Hour, noop, testEpoch.Add(-2*time.Hour))
+	a.AddJob("second", time.Hour, noop, testEpoch.Add(-2*time.Hour))
+	a.AddJob("delayed", time.Hour, noop, testEpoch.Add(-2*time.Hour), WithInitialDelay(time.Minute))
+
+	want := map[string]time.Time{
+		"first":   testEpoch.Add(-time.Hour),
+		"second":  testEpoch.Add(time.Minute),
+		"delayed": testEpoch.Add(3 * time.Minute),
+	}
+	for id, at := range want {
+		if next, _ := a.NextRun(id); !next.Equal(at) {
+			t.Errorf("%s: NextRun = %v, want %v", id, next, at)
+		}
+	}
+}
+
+func TestStaggerOnlyAppliesBeforeStart(t *testing.T) {
+	a, _ := newTestAggregator(t, 0, WithStagger(time.Minute))
+	a.Start()
+
+	for _, id := range []string{"first", "second", "third"} {
+		a.AddJob(id, time.Hour, noop, testEpoch)
+		if next, _ := a.NextRun(id); !next.Equal(testEpoch.Add(time.Hour)) {
+			t.Errorf("%s: NextRun = %v, want %v", id, next, testEpoch.Add(time.Hour))
+		}
+	}
+}
+
+func TestElapsedSlotsKeepsLatest(t *testing.T) {
+	now := testEpoch
+	for name, job := range map[string]*Job{
+		"interval": {Interval: time.Second, LastRun: now.Add(-7 * 24 * time.Hour)},
+		"cron":     {Schedule: MustParseCron("* * * * *"), LastRun: now.Add(-7 * 24 * time.Hour)},
+	} {
+		step := time.Second
+		if job.Schedule != nil {
+			step = time.Minute
+		}
+
+		slots := job.elapsedSlots(now)
+		if len(slots) != maxMissedRuns+1 {
+			t.Fatalf("%s: got %d slots, want %d", name, len(slots), maxMissedRuns+1)
+		}
+		for i, slot := range slots {
+			want := now.Add(-time.Duration(maxMissedRuns-i) * step)
+			if !slot.Equal(want) {
+				t.Errorf("%s: slot %d = %v, want %v", name, i, slot, want)
+				break
+			}
+		}
+	}
+}
+
+func TestMisfirePolicies(t *testing.T) {
+	down := testEpoch.Add(-4 * time.Hour)
+	slots := []time.Time{testEpoch.Add(-3 * time.Hour), testEpoch.Add(-2 * time.Hour), testEpoch.Add(-time.Hour), testEpoch}
+
+	type run struct {
+		trigger   TriggerReason
+		scheduled time.Time
+		missed    []time.Time
+	}
+	tests := []struct {
+		name    string
+		policy  MisfirePolicy
+		limit   int
+		lastRun time.Time
+		want    []run
+	}{
+		{"run once", MisfireRunOnce, 0, down, []run{
+			{TriggerSchedule, slots[3], slots[:3]},
+		}},
+		{"run all", MisfireRunAll, 0, down, []run{
+			{TriggerSchedule, slots[0], nil},
+			{TriggerCatchUp, slots[1], nil},
+			{TriggerCatchUp, slots[2], nil},
+			{TriggerCatchUp, slots[3], nil},
+		}},
+		{"run all capped", MisfireRunAll, 2, down, []run{
+			{TriggerSchedule, slots[2], slots[:2]},
+			{TriggerCatchUp, slots[3], nil},
+		}},
+		{"skip", MisfireSkip, 0, down, nil},
+		// A job that never ran has nothing to catch up on.
+		{"never ran", MisfireRunAll, 0, time.Time{}, []run{
+			{TriggerSchedule, testEpoch, nil},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAggregator(t, 1)
+
+			runs := make(chan run, 10)
+			a.AddJob("snapshot", time.Hour, func(ctx context.Context) error {
+				runs <- run{scheduled: ScheduledTime(ctx), missed: MissedRuns(ctx)}
+				return nil
+			}, tt.lastRun, WithMisfire(tt.policy, tt.limit))
+			events := subscribe(a, EventJobStarted, EventJobSkipped)
+
+			a.Start()
+			if tt.want == nil {
+				if e := waitEvent(t, events); e.Type != EventJobSkipped || e.Reason != SkipMisfire {
+					t.Fatalf("got %s (%s), want misfire skip", e.Type, e.Reason)
+				}
+				return
+			}
+			for i, want := range tt.want {
+				e := waitEvent(t, events)
+				got := <-runs
+				got.trigger = e.Trigger
+				if got.trigger != want.trigger || !got.scheduled.Equal(want.scheduled) || !slices.EqualFunc(got.missed, want.missed, time.Time.Equal) {
+					t.Errorf("run %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLockerSkipsRunsLeasedElsewhere(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := newTestAggregator(t, 1, WithLocker(NewFileLocker(dir, "a"), time.Hour))
+	if ok, err := NewFileLocker(dir, "b").Acquire(context.Background(), "scrape", time.Hour); err != nil || !ok {
+		t.Fatalf("Acquire() = %v, %v", ok, err)
+	}
+
+	a.AddJob("scrape", time.Hour, func(context.Context) error {
+		t.Error("job ran without holding the lease")
+		return nil
+	}, testEpoch)
+	skipped := subscribe(a, EventJobSkipped)
+
+	a.Start()
+	if err := a.TriggerNow("scrape"); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitEvent(t, skipped); e.Reason != SkipNotLeader {
+		t.Errorf("Reason = %q, want %q", e.Reason, SkipNotLeader)
+	}
+}
+
+func TestLockerKeepsJobOnOneInstance(t *testing.T) {
+	dir := t.TempDir()
+	const ttl = 20 * time.Millisecond
+	a, fakeA := newTestAggregator(t, 1, WithLocker(NewFileLocker(dir, "a"), ttl))
+	b, fakeB := newTestAggregator(t, 1, WithLocker(NewFileLocker(dir, "b"), ttl))
+
+	// The schedules of both instances are half an interval apart.
+	a.AddJob("scrape", time.Hour, noop, testEpoch.Add(-time.Hour))
+	b.AddJob("scrape", time.Hour, func(context.Context) error {
+		t.Error("job ran on a second instance")
+		return nil
+	}, testEpoch.Add(-30*time.Minute))
+	ran := subscribe(a, EventJobSucceeded)
+	skipped := subscribe(b, EventJobSkipped)
+	a.Start()
+	b.Start()
+
+	for step := range 6 {
+		now := testEpoch.Add(time.Duration(step) * 30 * time.Minute)
+		fakeA.Set(now)
+		fakeB.Set(now)
+		if step%2 == 0 {
+			waitEvent(t, ran)
+		} else if e := waitEvent(t, skipped); e.Reason != SkipNotLeader {
+			t.Errorf("Reason = %q, want %q", e.Reason, SkipNotLeader)
+		}
+		// Well past the lease TTL in real time.
+		time.Sleep(3 * ttl)
+	}
+}
+
+func TestLeaseRenewalWaitsForBusyGuard(t *testing.T) {
+	dir := t.TempDir()
+	locker := NewFileLocker(dir, "a")
+	a, fake := newTestAggregator(t, 1, WithLocker(locker, 30*time.Second))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	a.AddJob("scrape", time.Hour, func(ctx context.Context) error {
+		close(started)
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}, testEpoch)
+	succeeded := subscribe(a, EventJobSucceeded, EventJobFailed)
+
+	a.Start()
+	if err := a.TriggerNow("scrape"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// Another instance holds the guard while the renewal runs.
+	guard := locker.path("scrape") + ".guard"
+	if err := os.WriteFile(guard, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { os.Remove(guard) })
+	fake.Advance(10 * time.Second)
+	time.Sleep(100 * time.Millisecond)
+
+	close(release)
+	if e := waitEvent(t, succeeded); e.Type != EventJobSucceeded {
+		t.Errorf("run ended with %s (%v), want success", e.Type, e.Err)
+	}
+}
+
+func blocking(release <-chan struct{}) func(context.Context) error {
+	return func(context.Context) error {
+		<-release
+		return nil
+	}
+}
+
+func TestSetWorkersResizesPool(t *testing.T) {
+	a, _ := newTestAggregator(t, 1)
+
+	release := make(chan struct{})
+	for _, id := range []string{"a", "b", "c"} {
+		a.AddJob(id, time.Hour, blocking(release), testEpoch)
+	}
+	started := subscribe(a, EventJobStarted)
+	succeeded := subscribe(a, EventJobSucceeded)
+
+	a.Start()
+	for _, id := range []string{"a", "b"} {
+		if err := a.TriggerNow(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitEvent(t, started)
+
+	a.SetWorkers(2)
+	waitEvent(t, started)
+	if got := a.Workers(); got != 2 {
+		t.Errorf("Workers() = %d, want 2", got)
+	}
+
+	// Shrinking lets both running jobs finish.
+	a.SetWorkers(0)
+	close(release)
+	waitEvent(t, succeeded)
+	waitEvent(t, succeeded)
+	if got := a.Workers(); got != 0 {
+		t.Errorf("Workers() = %d, want 0", got)
+	}
+
+	if err := a.TriggerNow("c"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-started:
+		t.Errorf("job %q started without workers", e.JobID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAutoScaleAddsWorkersWhileQueueIsBackedUp(t *testing.T) {
+	a, _ := newTestAggregator(t, 1, WithAutoScale(3))
+
+	release := make(chan struct{})
+	ids := []string{"a", "b", "c", "d"}
+	for _, id := range ids {
+		a.AddJob(id, time.Hour, blocking(release), testEpoch)
+	}
+	started := subscribe(a, EventJobStarted)
+	succeeded := subscribe(a, EventJobSucceeded)
+
+	a.Start()
+	for _, id := range ids {
+		if err := a.TriggerNow(id); err != nil {
+			t.Fatal(err)
+		}
+		if id != "d" {
+			waitEvent(t, started)
+		}
+	}
+	if got := a.Workers(); got != 3 {
+		t.Errorf("Workers() = %d, want 3", got)
+	}
+
+	close(release)
+	for range ids {
+		waitEvent(t, succeeded)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for a.Workers() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := a.Workers(); got != 1 {
+		t.Errorf("Workers() after draining = %d, want 1", got)
+	}
+}
+
+func TestAddJobUpdatesRegisteredJobInPlace(t *testing.T) {
+	a, _ := newTestAggregator(t, 1)
+
+	release := make(chan struct{})
+	lastRun := testEpoch.Add(-10 * time.Minute)
+	a.AddJob("scrape", time.Hour, blocking(release), lastRun)
+	started := subscribe(a, EventJobStarted)
+	succeeded := subscribe(a, EventJobSucceeded)
+
+	a.Start()
+	if err := a.TriggerNow("scrape"); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, started)
+
+	updated := make(chan struct{}, 1)
+	a.AddJob("scrape", 30*time.Minute, func(context.Context) error {
+		updated <- struct{}{}
+		return nil
+	}, testEpoch)
+
+	info := jobInfo(t, a, "scrape")
+	if info.Status != StatusRunning {
+		t.Errorf("Status = %q, want the run to continue", info.Status)
+	}
+	if !info.LastRun.Equal(lastRun) || !info.NextRun.Equal(lastRun.Add(30*time.Minute)) {
+		t.Errorf("LastRun = %v, NextRun = %v, want the kept LastRun with the new interval", info.LastRun, info.NextRun)
+	}
+
+	close(release)
+	waitEvent(t, succeeded)
+	select {
+	case <-updated:
+		t.Fatal("running execution switched to the new definition")
+	default:
+	}
+
+	if err := a.TriggerNow("scrape"); err != nil {
+		t.Fatal(err)
+	}
+	<-updated
+}
+
+func TestErrorHandlerAndParentContext(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failures := make(chan string, 1)
+	a, _ := newTestAggregator(t, 1,
+		WithContext(parent),
+		WithErrorHandler(func(jobID string, err error) {
+			failures <- jobID + ": " + err.Error()
+		}))
+
+	a.AddJob("broken", time.Hour, func(context.Context) error {
+		return errors.New("503 Service Unavailable")
+	}, testEpoch)
+	a.Start()
+	if err := a.TriggerNow("broken"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-failures:
+		if got != "broken: 503 Service Unavailable" {
+			t.Errorf("error handler got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("error handler was not called")
+	}
+
+	cancel()
+	select {
+	case <-a.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("cancelling the parent context did not stop the aggregator")
+	}
+}
+
+func TestStopClosesSubscriptions(t *testing.T) {
+	a, _ := newTestAggregator(t, 1)
+	unsubscribe := a.Subscribe(ListenerFunc(func(Event) {}))
+	subscribe(a, EventJobSucceeded)
+	a.Start()
+	a.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		a.events.mu.RLock()
+		n := len(a.events.subs)
+		a.events.mu.RUnlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscriptions still open after Stop", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	unsubscribe()
+	a.Subscribe(ListenerFunc(func(Event) {}))()
+}
+
+func TestShutdownWaitsForRunningJobs(t *testing.T) {
+	a, _ := newTestAggregator(t, 1)
+
+	release := make(chan struct{})
+	a.AddJob("slow", time.Hour, blocking(release), testEpoch)
+	started := subscribe(a, EventJobStarted)
+	a.Start()
+	if err := a.TriggerNow("slow"); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, started)
+
+	stopped := make(chan error, 1)
+	go func() {
+		_, err := a.Shutdown(context.Background())
+		stopped <- err
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown returned while the job was still running")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if got := jobInfo(t, a, "slow").LastResult; got != ResultSucceeded {
+		t.Errorf("LastResult = %q, want %q", got, ResultSucceeded)
+	}
+}
+
+func TestShutdownInterruptsRunsAtDeadline(t *testing.T) {
+	var failed []string
+	a, _ := newTestAggregator(t, 1, WithErrorHandler(func(jobID string, err error) {
+		failed = append(failed, jobID)
+	}))
+
+	a.AddJob("slow", time.Hour, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, testEpoch)
+	a.AddJob("queued", time.Hour, noop, testEpoch)
+	started := subscribe(a, EventJobStarted)
+	cancelled := subscribe(a, EventJobCancelled, EventJobFailed)
+	skipped := subscribe(a, EventJobSkipped)
+	a.Start()
+	if err := a.TriggerNow("slow"); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, started)
+	if err := a.TriggerNow("queued"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	interrupted, err := a.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !slices.Equal(interrupted, []string{"slow"}) {
+		t.Errorf("interrupted = %v, want [slow]", interrupted)
+	}
+
+	e := waitEvent(t, cancelled)
+	if e.Type != EventJobCancelled || e.Run.Result != ResultInterrupted {
+		t.Errorf("got %s with result %q, want %s with %q", e.Type, e.Run.Result, EventJobCancelled, ResultInterrupted)
+	}
+	if e := waitEvent(t, skipped); e.JobID != "queued" || e.Reason != SkipShutdown {
+		t.Errorf("skipped %s (%s), want queued (%s)", e.JobID, e.Reason, SkipShutdown)
+	}
+
+	if len(failed) > 0 {
+		t.Errorf("error handler called for %v", failed)
+	}
+	if info := jobInfo(t, a, "slow"); info.LastResult != ResultInterrupted || info.ConsecutiveFailures != 0 {
+		t.Errorf("slow: LastResult = %q, ConsecutiveFailures = %d; want %q, 0", info.LastResult, info.ConsecutiveFailures, ResultInterrupted)
+	}
+	if got := jobInfo(t, a, "queued").Status; got != StatusIdle {
+		t.Errorf("queued: Status = %q, want %q", got, StatusIdle)
+	}
+}
+
+func TestOverlapQueueRunsPendingRunAfterCurrent(t *testing.T) {
+	a, _ := newTestAggregator(t, 2)
+
+	release := make(chan struct{})
+	a.AddJob("scrape", time.Hour, blocking(release), testEpoch, WithOverlap(OverlapQueue))
+	started := subscribe(a, EventJobStarted)
+	a.Start()
+	if err := a.TriggerNow("scrape"); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, started)
+
+	// Only a single pending run is kept.
+	for range 2 {
+		if err := a.TriggerNow("scrape"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case e := <-started:
+		t.Fatalf("pending run started at %s while the previous was running", e.Trigger)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	if e := waitEvent(t, started); e.Trigger != TriggerPending {
+		t.Errorf("Trigger = %q, want %q", e.Trigger, TriggerPending)
+	}
+	select {
+	case e := <-started:
+		t.Errorf("unexpected extra run (%s)", e.Trigger)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestOverlapCancelPreviousIsNotAFailure(t *testing.T) {
+	failed := make(chan string, 10)
+	a, _ := newTestAggregator(t, 2, WithErrorHandler(func(jobID string, err error) {
+		failed <- jobID
+	}))
+
+	var runs atomic.Int32
+	a.AddJob("scrape", time.Hour, func(ctx context.Context) error {
+		if runs.Add(1) > 1 {
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}, testEpoch, WithOverlap(OverlapCancelPrevious))
+	started := subscribe(a, EventJobStarted)
+	finished := subscribe(a, EventJobSucceeded, EventJobFailed, EventJobCancelled)
+	a.Start()
+	if err := a.TriggerNow("scrape"); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, started)
+	if err := a.TriggerNow("scrape"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := waitEvent(t, finished)
+	if e.Type != EventJobCancelled || e.Run.Result != ResultCancelled {
+		t.Errorf("previous run: got %s with result %q, want %s with %q", e.Type, e.Run.Result, EventJobCancelled, ResultCancelled)
+	}
+	if e := waitEvent(t, finished); e.Type != EventJobSucceeded {
+		t.Errorf("new run: got %s, want %s", e.Type, EventJobSucceeded)
+	}
+
+	if len(failed) > 0 {
+		t.Errorf("error handler called for %s", <-failed)
+	}
+	if got := jobInfo(t, a, "scrape").ConsecutiveFailures; got != 0 {
+		t.Errorf("ConsecutiveFailures = %d, want 0", got)
+	}
+}
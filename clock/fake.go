@@ -0,0 +1,246 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance or Set is called.
+// Timers, tickers and AfterFunc callbacks fire synchronously from Advance,
+// except for non-positive durations, which fire immediately like their real
+// counterparts.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	clock  *Fake
+	when   time.Time
+	period time.Duration
+	fn     func()
+	ch     chan time.Time
+	active bool
+}
+
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	w := &fakeWaiter{clock: f, period: d, ch: make(chan time.Time, 1)}
+	f.add(w, d)
+	return fakeTicker{w}
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	w := &fakeWaiter{clock: f, ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.fire(f.Now())
+		return fakeTimer{w}
+	}
+	f.add(w, d)
+	return fakeTimer{w}
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	w := &fakeWaiter{clock: f, fn: fn}
+	if d <= 0 {
+		go fn()
+		return fakeTimer{w}
+	}
+	f.add(w, d)
+	return fakeTimer{w}
+}
+
+// Advance moves the clock forward by d, firing everything that becomes due
+// in chronological order.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t, firing everything due at or before t.
+func (f *Fake) Set(t time.Time) {
+	for {
+		f.mu.Lock()
+		w := f.nextDue(t)
+		if w == nil {
+			if t.After(f.now) {
+				f.now = t
+			}
+			f.mu.Unlock()
+			return
+		}
+
+		if w.when.After(f.now) {
+			f.now = w.when
+		}
+		now := f.now
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			f.remove(w)
+		}
+		f.mu.Unlock()
+
+		w.fire(now)
+	}
+}
+
+// BlockUntil waits until at least n timers, tickers or AfterFunc callbacks
+// are pending, which lets tests synchronise with goroutines arming them.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// Pending returns the number of armed timers, tickers and callbacks.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+func (f *Fake) add(w *fakeWaiter, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.when = f.now.Add(d)
+	w.active = true
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+}
+
+func (f *Fake) remove(w *fakeWaiter) bool {
+	if !w.active {
+		return false
+	}
+	w.active = false
+	f.waiters = slices.DeleteFunc(f.waiters, func(other *fakeWaiter) bool {
+		return other == w
+	})
+	return true
+}
+
+func (f *Fake) nextDue(t time.Time) *fakeWaiter {
+	var next *fakeWaiter
+	for _, w := range f.waiters {
+		if w.when.After(t) {
+			continue
+		}
+		if next == nil || w.when.Before(next.when) {
+			next = w
+		}
+	}
+	return next
+}
+
+func (w *fakeWaiter) fire(now time.Time) {
+	if w.fn != nil {
+		w.fn()
+		return
+	}
+	select {
+	case w.ch <- now:
+	default:
+	}
+}
+
+func (w *fakeWaiter) stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	if w.ch != nil {
+		select {
+		case <-w.ch:
+		default:
+		}
+	}
+	return w.clock.remove(w)
+}
+
+// reset re-arms w. Like time.Timer since Go 1.23, a value left in the channel
+// by an earlier firing is discarded.
+func (w *fakeWaiter) reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	wasActive := w.clock.remove(w)
+	if w.ch != nil {
+		select {
+		case <-w.ch:
+		default:
+		}
+	}
+
+	if d <= 0 && w.period == 0 {
+		now := w.clock.now
+		w.clock.mu.Unlock()
+		if w.fn != nil {
+			go w.fn()
+		} else {
+			w.fire(now)
+		}
+		return wasActive
+	}
+
+	w.when = w.clock.now.Add(d)
+	w.active = true
+	w.clock.waiters = append(w.clock.waiters, w)
+	w.clock.cond.Broadcast()
+	w.clock.mu.Unlock()
+	return wasActive
+}
+
+type fakeTicker struct {
+	w *fakeWaiter
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clock: non-positive interval for Ticker.Reset")
+	}
+	t.w.clock.mu.Lock()
+	t.w.period = d
+	t.w.clock.mu.Unlock()
+	t.w.reset(d)
+}
+
+func (t fakeTicker) Stop() {
+	t.w.stop()
+}
+
+type fakeTimer struct {
+	w *fakeWaiter
+}
+
+func (t fakeTimer) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t fakeTimer) Reset(d time.Duration) bool {
+	return t.w.reset(d)
+}
+
+func (t fakeTimer) Stop() bool {
+	return t.w.stop()
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	agg "github.com/kabinasoftware/jobs-agg"
+	"github.com/kabinasoftware/jobs-agg/models"
+	"github.com/kabinasoftware/jobs-agg/worker"
+	"github.com/kabinasoftware/jobs-agg/worker/nofluffjobs"
+	"github.com/kabinasoftware/jobs-agg/worker/pracuj"
+)
+
+// New creates an aggregator from the configuration and registers its jobs.
+// opts are applied after the configured options.
+func (c *Config) New(opts ...agg.Option) (*agg.Aggregator, error) {
+	a := agg.New(c.Aggregator.Workers, append(c.options(), opts...)...)
+	if err := c.Apply(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (c *Config) options() []agg.Option {
+	ac := c.Aggregator
+
+	var opts []agg.Option
+	if ac.StateFile != "" {
+		opts = append(opts, agg.WithStore(agg.NewFileStore(ac.StateFile)))
+	}
+	if ac.LockDir != "" {
+		opts = append(opts, agg.WithLocker(agg.NewFileLocker(ac.LockDir, ""), ac.LeaseTTL))
+	}
+	if ac.MaxWorkers > 0 {
+		opts = append(opts, agg.WithAutoScale(ac.MaxWorkers))
+	}
+	if ac.Stagger > 0 {
+		opts = append(opts, agg.WithStagger(ac.Stagger))
+	}
+	if ac.QuarantineAfter > 0 {
+		opts = append(opts, agg.WithQuarantine(ac.QuarantineAfter))
+	}
+	for _, group := range sortedKeys(ac.GroupLimits) {
+		opts = append(opts, agg.WithGroupLimit(group, ac.GroupLimits[group]))
+	}
+	return opts
+}
+
+// Apply registers the configured jobs with a. Jobs already registered under
+// the same ID are updated in place. New jobs take their LastRun from the
+// aggregator's state file, so jobs that never ran are due at once.
+func (c *Config) Apply(a *agg.Aggregator) error {
+	return c.apply(a, func(JobConfig) bool { return true })
+}
+
+func (c *Config) apply(a *agg.Aggregator, filter func(JobConfig) bool) error {
+	workers := make(map[string]worker.Worker, len(c.Workers))
+	for name, wc := range c.Workers {
+		workers[name] = wc.build()
+	}
+
+	// Build every job before registering any, so that a job failing to
+	// build leaves a untouched.
+	type registration struct {
+		index   int
+		execute func(ctx context.Context) error
+	}
+	var jobs []registration
+	for _, i := range c.order() {
+		if !filter(c.Jobs[i]) {
+			continue
+		}
+		execute, err := c.execute(i, c.Jobs[i], workers)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, registration{i, execute})
+	}
+
+	for _, job := range jobs {
+		jc := c.Jobs[job.index]
+
+		var err error
+		if jc.schedule != nil {
+			err = a.AddScheduledJob(jc.ID, jc.schedule, job.execute, time.Time{}, jc.options()...)
+		} else {
+			err = a.AddJob(jc.ID, jc.Interval, job.execute, time.Time{}, jc.options()...)
+		}
+		if err != nil {
+			return c.errorAt(err, "jobs", job.index, "depends_on")
+		}
+	}
+	return nil
+}
+
+// order returns the indexes of the jobs with every job after its
+// dependencies. Registering jobs in this order keeps updated dependencies
+// from forming a cycle with a dependent's previous definition.
+func (c *Config) order() []int {
+	index := make(map[string]int, len(c.Jobs))
+	for i, jc := range c.Jobs {
+		index[jc.ID] = i
+	}
+
+	var (
+		order []int
+		visit func(i int)
+	)
+	seen := make([]bool, len(c.Jobs))
+	visit = func(i int) {
+		if seen[i] {
+			return
+		}
+		seen[i] = true
+		for _, dep := range c.Jobs[i].DependsOn {
+			if k, ok := index[dep]; ok {
+				visit(k)
+			}
+		}
+		order = append(order, i)
+	}
+	for i := range c.Jobs {
+		visit(i)
+	}
+	return order
+}
+
+func (wc WorkerConfig) build() worker.Worker {
+	client := http.DefaultClient
+	if wc.HTTP.Timeout > 0 {
+		client = &http.Client{Timeout: wc.HTTP.Timeout}
+	}
+
+	switch wc.Source {
+	case nofluffjobs.Source:
+		return nofluffjobs.Init(&nofluffjobs.Options{
+			BaseURL:    wc.BaseURL,
+			HTTPClient: client,
+			RawSearch:  wc.Filters["search"],
+		})
+	default:
+		var query url.Values
+		if len(wc.Filters) > 0 {
+			query = make(url.Values, len(wc.Filters))
+			for key, value := range wc.Filters {
+				query.Set(key, value)
+			}
+		}
+		return pracuj.Init(&pracuj.Options{
+			BaseURL:    wc.BaseURL,
+			HTTPClient: client,
+			Query:      query,
+		})
+	}
+}
+
+func (c *Config) execute(i int, jc JobConfig, workers map[string]worker.Worker) (func(ctx context.Context) error, error) {
+	if jc.Task != "" {
+		fn, _ := lookupTask(jc.Task)
+		return fn, nil
+	}
+
+	configs := jc.Sinks
+	if len(configs) == 0 {
+		configs = []SinkConfig{{Type: "log"}}
+	}
+	sinks := make([]Sink, 0, len(configs))
+	for k, sc := range configs {
+		factory, _ := lookupSink(sc.Type)
+		sink, err := factory(sc.Params)
+		if err != nil {
+			return nil, c.errorAt(err, "jobs", i, "sinks", k)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return scrape(jc.ID, workers[jc.Worker], sinks), nil
+}
+
+// sinkBatchSize is the number of offers handed to the sinks at once, about a
+// listing page, so that sinks such as the file sink do not open their output
+// for every offer.
+const sinkBatchSize = 50
+
+// scrape returns a job streaming every page of offers from w into sinks.
+// Offers fetched before an error are still written.
+func scrape(job string, w worker.Worker, sinks []Sink) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		batch := make([]*models.Offer, 0, sinkBatchSize)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			for _, sink := range sinks {
+				if err := sink.Write(ctx, job, batch); err != nil {
+					return fmt.Errorf("failed to write offers: %w", err)
+				}
+			}
+			batch = make([]*models.Offer, 0, sinkBatchSize)
+			return nil
+		}
+
+		for offer, err := range worker.Stream(ctx, w) {
+			if err != nil {
+				return errors.Join(err, flush())
+			}
+
+			batch = append(batch, offer)
+			if len(batch) == sinkBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return flush()
+	}
+}
+
+func (jc JobConfig) options() []agg.JobOption {
+	opts := []agg.JobOption{
+		agg.WithPriority(jc.Priority),
+		agg.WithOverlap(jc.overlap),
+		agg.WithMisfire(jc.misfire, jc.MisfireLimit),
+	}
+	if r := jc.Retry; r != nil {
+		opts = append(opts, agg.WithRetry(agg.RetryPolicy{
+			MaxAttempts: r.MaxAttempts,
+			BaseDelay:   r.BaseDelay,
+			MaxDelay:    r.MaxDelay,
+			Jitter:      r.Jitter,
+		}))
+	}
+	if jc.Timeout > 0 {
+		opts = append(opts, agg.WithTimeout(jc.Timeout))
+	}
+	if jc.Group != "" {
+		opts = append(opts, agg.InGroup(jc.Group))
+	}
+	if jc.Jitter > 0 {
+		opts = append(opts, agg.WithJitter(jc.Jitter))
+	}
+	if len(jc.DependsOn) > 0 {
+		opts = append(opts, agg.DependsOn(jc.DependsOn...))
+	}
+	return opts
+}
@@ -0,0 +1,425 @@
+// Package config builds an Aggregator, its scrapers and its jobs from a YAML
+// file.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	agg "github.com/kabinasoftware/jobs-agg"
+	"github.com/kabinasoftware/jobs-agg/worker/nofluffjobs"
+	"github.com/kabinasoftware/jobs-agg/worker/pracuj"
+)
+
+// Config describes an aggregator, the workers scraping job boards and the
+// jobs running them.
+type Config struct {
+	Aggregator AggregatorConfig        `yaml:"aggregator"`
+	Workers    map[string]WorkerConfig `yaml:"workers"`
+	Jobs       []JobConfig             `yaml:"jobs"`
+
+	root *yaml.Node
+}
+
+type AggregatorConfig struct {
+	Workers int `yaml:"workers"`
+	// MaxWorkers enables auto-scaling up to the given number of workers.
+	MaxWorkers      int            `yaml:"max_workers"`
+	StateFile       string         `yaml:"state_file"`
+	LockDir         string         `yaml:"lock_dir"`
+	LeaseTTL        time.Duration  `yaml:"lease_ttl"`
+	Stagger         time.Duration  `yaml:"stagger"`
+	QuarantineAfter int            `yaml:"quarantine_after"`
+	GroupLimits     map[string]int `yaml:"group_limits"`
+}
+
+type WorkerConfig struct {
+	// Source selects the job board, either pracuj.pl or nofluffjobs.com.
+	Source  string `yaml:"source"`
+	BaseURL string `yaml:"base_url"`
+	// Filters narrows the search. pracuj.pl takes listing query
+	// parameters, nofluffjobs.com a single "search" expression.
+	Filters map[string]string `yaml:"filters"`
+	HTTP    HTTPConfig        `yaml:"http"`
+}
+
+type HTTPConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// JobConfig describes a job. A job either scrapes a worker into its sinks or
+// runs a task registered with RegisterTask.
+type JobConfig struct {
+	ID     string `yaml:"id"`
+	Worker string `yaml:"worker"`
+	Task   string `yaml:"task"`
+	// Interval and Schedule are mutually exclusive. Schedule is a cron
+	// expression evaluated in Timezone, the local time zone by default.
+	Interval     time.Duration `yaml:"interval"`
+	Schedule     string        `yaml:"schedule"`
+	Timezone     string        `yaml:"timezone"`
+	Retry        *RetryConfig  `yaml:"retry"`
+	Timeout      time.Duration `yaml:"timeout"`
+	Priority     int           `yaml:"priority"`
+	Group        string        `yaml:"group"`
+	Jitter       time.Duration `yaml:"jitter"`
+	Overlap      string        `yaml:"overlap"`
+	Misfire      string        `yaml:"misfire"`
+	MisfireLimit int           `yaml:"misfire_limit"`
+	DependsOn    []string      `yaml:"depends_on"`
+	Sinks        []SinkConfig  `yaml:"sinks"`
+
+	schedule agg.Schedule
+	overlap  agg.OverlapPolicy
+	misfire  agg.MisfirePolicy
+}
+
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	Jitter      float64       `yaml:"jitter"`
+}
+
+// SinkConfig names a sink registered with RegisterSink. It is written either
+// as the bare sink name or as a mapping with a "type" key and the sink's
+// parameters.
+type SinkConfig struct {
+	Type   string
+	Params map[string]string
+}
+
+func (s *SinkConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&s.Type)
+	}
+
+	if err := node.Decode(&s.Params); err != nil {
+		return err
+	}
+	s.Type = s.Params["type"]
+	delete(s.Params, "type")
+	return nil
+}
+
+// Error is a problem with the value at Path, such as jobs[1].schedule, found
+// on Line of the configuration file.
+type Error struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("config: %s (line %d): %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("config: %s: %v", e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a YAML configuration. Validation errors are
+// returned joined, each as an *Error.
+func Parse(data []byte) (*Config, error) {
+	var c Config
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	c.root = new(yaml.Node)
+	if err := yaml.Unmarshal(data, c.root); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	fail := func(err error, path ...any) {
+		errs = append(errs, c.errorAt(err, path...))
+	}
+
+	a := c.Aggregator
+	if a.Workers < 0 {
+		fail(errors.New("must not be negative"), "aggregator", "workers")
+	}
+	if a.MaxWorkers != 0 && a.MaxWorkers < a.Workers {
+		fail(errors.New("must not be below workers"), "aggregator", "max_workers")
+	}
+	if a.LeaseTTL < 0 {
+		fail(errors.New("must not be negative"), "aggregator", "lease_ttl")
+	}
+	if a.Stagger < 0 {
+		fail(errors.New("must not be negative"), "aggregator", "stagger")
+	}
+	for _, group := range sortedKeys(a.GroupLimits) {
+		if a.GroupLimits[group] < 1 {
+			fail(errors.New("must be at least 1"), "aggregator", "group_limits", group)
+		}
+	}
+
+	for _, name := range sortedKeys(c.Workers) {
+		w := c.Workers[name]
+		switch w.Source {
+		case pracuj.Source:
+		case nofluffjobs.Source:
+			for _, key := range sortedKeys(w.Filters) {
+				if key != "search" {
+					fail(fmt.Errorf("unknown filter %q for %s, expected search", key, w.Source), "workers", name, "filters", key)
+				}
+			}
+		case "":
+			fail(errors.New("is required"), "workers", name, "source")
+		default:
+			fail(fmt.Errorf("unknown source %q, expected %s or %s", w.Source, pracuj.Source, nofluffjobs.Source), "workers", name, "source")
+		}
+		if w.BaseURL != "" {
+			if u, err := url.Parse(w.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+				fail(fmt.Errorf("invalid URL %q", w.BaseURL), "workers", name, "base_url")
+			}
+		}
+		if w.HTTP.Timeout < 0 {
+			fail(errors.New("must not be negative"), "workers", name, "http", "timeout")
+		}
+	}
+
+	ids := make(map[string]bool, len(c.Jobs))
+	for i := range c.Jobs {
+		j := &c.Jobs[i]
+
+		switch {
+		case j.ID == "":
+			fail(errors.New("is required"), "jobs", i, "id")
+		case ids[j.ID]:
+			fail(fmt.Errorf("duplicate job id %q", j.ID), "jobs", i, "id")
+		}
+		ids[j.ID] = true
+
+		switch {
+		case j.Worker != "" && j.Task != "":
+			fail(errors.New("cannot be combined with worker"), "jobs", i, "task")
+		case j.Worker != "":
+			if _, ok := c.Workers[j.Worker]; !ok {
+				fail(fmt.Errorf("unknown worker %q", j.Worker), "jobs", i, "worker")
+			}
+		case j.Task != "":
+			if _, ok := lookupTask(j.Task); !ok {
+				fail(fmt.Errorf("unknown task %q", j.Task), "jobs", i, "task")
+			}
+			if len(j.Sinks) > 0 {
+				fail(errors.New("only apply to jobs with a worker"), "jobs", i, "sinks")
+			}
+		default:
+			fail(errors.New("one of worker or task is required"), "jobs", i)
+		}
+
+		loc := time.Local
+		if j.Timezone != "" {
+			l, err := time.LoadLocation(j.Timezone)
+			if err != nil {
+				fail(fmt.Errorf("unknown time zone %q", j.Timezone), "jobs", i, "timezone")
+			}
+			if j.Schedule == "" {
+				fail(errors.New("requires schedule"), "jobs", i, "timezone")
+			}
+			loc = l
+		}
+		switch {
+		case j.Schedule != "" && j.Interval != 0:
+			fail(errors.New("cannot be combined with interval"), "jobs", i, "schedule")
+		case j.Schedule != "":
+			s, err := agg.ParseCronInLocation(j.Schedule, loc)
+			if err != nil {
+				fail(err, "jobs", i, "schedule")
+			}
+			j.schedule = s
+		case j.Interval < 0:
+			fail(errors.New("must not be negative"), "jobs", i, "interval")
+		case j.Interval == 0 && len(j.DependsOn) == 0:
+			fail(errors.New("one of interval, schedule or depends_on is required"), "jobs", i)
+		}
+
+		if r := j.Retry; r != nil {
+			if r.MaxAttempts < 0 {
+				fail(errors.New("must not be negative"), "jobs", i, "retry", "max_attempts")
+			}
+			if r.Jitter < 0 || r.Jitter > 1 {
+				fail(errors.New("must be between 0 and 1"), "jobs", i, "retry", "jitter")
+			}
+		}
+		if j.Timeout < 0 {
+			fail(errors.New("must not be negative"), "jobs", i, "timeout")
+		}
+		if j.Jitter < 0 {
+			fail(errors.New("must not be negative"), "jobs", i, "jitter")
+		}
+
+		var err error
+		if j.overlap, err = parseOverlap(j.Overlap); err != nil {
+			fail(err, "jobs", i, "overlap")
+		}
+		if j.misfire, err = parseMisfire(j.Misfire); err != nil {
+			fail(err, "jobs", i, "misfire")
+		}
+
+		for k, sink := range j.Sinks {
+			if _, ok := lookupSink(sink.Type); !ok {
+				fail(fmt.Errorf("unknown sink %q", sink.Type), "jobs", i, "sinks", k)
+			}
+		}
+	}
+
+	for i, j := range c.Jobs {
+		for k, dep := range j.DependsOn {
+			if !ids[dep] {
+				fail(fmt.Errorf("unknown job %q", dep), "jobs", i, "depends_on", k)
+			}
+		}
+	}
+	c.checkCycles(fail)
+
+	return errors.Join(errs...)
+}
+
+// checkCycles reports every dependency cycle among the jobs at the
+// depends_on of the job closing it.
+func (c *Config) checkCycles(fail func(err error, path ...any)) {
+	index := make(map[string]int, len(c.Jobs))
+	for i, j := range c.Jobs {
+		if _, ok := index[j.ID]; !ok {
+			index[j.ID] = i
+		}
+	}
+
+	var (
+		path  []string
+		visit func(id string)
+	)
+	done := make(map[string]bool)
+	visit = func(id string) {
+		if k := slices.Index(path, id); k >= 0 {
+			cycle := append(slices.Clone(path[k:]), id)
+			fail(fmt.Errorf("%w: %s", agg.ErrDependencyCycle, strings.Join(cycle, " -> ")),
+				"jobs", index[path[len(path)-1]], "depends_on")
+			return
+		}
+		i, ok := index[id]
+		if done[id] || !ok {
+			return
+		}
+		path = append(path, id)
+		for _, dep := range c.Jobs[i].DependsOn {
+			visit(dep)
+		}
+		path = path[:len(path)-1]
+		done[id] = true
+	}
+	for _, j := range c.Jobs {
+		visit(j.ID)
+	}
+}
+
+func parseOverlap(s string) (agg.OverlapPolicy, error) {
+	for _, p := range []agg.OverlapPolicy{agg.OverlapSkip, agg.OverlapQueue, agg.OverlapCancelPrevious} {
+		if s == "" || s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overlap policy %q, expected skip, queue or cancel_previous", s)
+}
+
+func parseMisfire(s string) (agg.MisfirePolicy, error) {
+	for _, p := range []agg.MisfirePolicy{agg.MisfireRunOnce, agg.MisfireRunAll, agg.MisfireSkip} {
+		if s == "" || s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown misfire policy %q, expected run_once, run_all or skip", s)
+}
+
+// errorAt wraps err in an *Error for the value at path, made of mapping keys
+// and sequence indexes. The line is that of the closest existing node.
+func (c *Config) errorAt(err error, path ...any) *Error {
+	var (
+		b    strings.Builder
+		line int
+	)
+
+	node := c.root
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+		line = node.Line
+	}
+	for _, p := range path {
+		switch p := p.(type) {
+		case int:
+			b.WriteString("[" + strconv.Itoa(p) + "]")
+			if node != nil && node.Kind == yaml.SequenceNode && p < len(node.Content) {
+				node = node.Content[p]
+			} else {
+				node = nil
+			}
+		case string:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(p)
+			node = child(node, p)
+		}
+		if node != nil {
+			line = node.Line
+		}
+	}
+
+	return &Error{Path: b.String(), Line: line, Err: err}
+}
+
+// child returns the value of key in a mapping node, or nil.
+func child(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,401 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	agg "github.com/kabinasoftware/jobs-agg"
+	"github.com/kabinasoftware/jobs-agg/models"
+)
+
+const validConfig = `
+aggregator:
+  workers: 2
+  group_limits:
+    pracuj.pl: 1
+workers:
+  pracuj:
+    source: pracuj.pl
+    filters:
+      wm: home-office
+      cc: "5016"
+    http:
+      timeout: 30s
+jobs:
+  - id: pracuj-scraper
+    worker: pracuj
+    schedule: "0 6,18 * * *"
+    timezone: Europe/Warsaw
+    retry:
+      max_attempts: 3
+      base_delay: 1m
+    timeout: 2h
+    group: pracuj.pl
+    sinks:
+      - log
+      - type: file
+        path: offers.jsonl
+  - id: cleanup
+    task: test-cleanup
+    depends_on: [pracuj-scraper]
+`
+
+func init() {
+	RegisterTask("test-cleanup", func(context.Context) error { return nil })
+}
+
+func TestParseBuildsAggregator(t *testing.T) {
+	c, err := Parse([]byte(validConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := c.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	jobs := a.ListJobs()
+	if len(jobs) != 2 || jobs[0].ID != "cleanup" || jobs[1].ID != "pracuj-scraper" {
+		t.Fatalf("ListJobs() = %+v, want cleanup and pracuj-scraper", jobs)
+	}
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hour := jobs[1].NextRun.In(warsaw).Hour(); hour != 6 && hour != 18 {
+		t.Errorf("pracuj-scraper NextRun = %v, want 6:00 or 18:00 in Warsaw", jobs[1].NextRun)
+	}
+}
+
+func TestParseReportsPathAndLine(t *testing.T) {
+	_, err := Parse([]byte(`
+workers:
+  pracuj:
+    source: indeed
+jobs:
+  - id: scraper
+    worker: pracuj
+    schedule: "0 25 * * *"
+  - id: scraper
+    task: missing
+    interval: 1h
+    overlap: sometimes
+`))
+	if err == nil {
+		t.Fatal("Parse() succeeded, want validation errors")
+	}
+
+	for _, want := range []string{
+		"workers.pracuj.source (line 4): unknown source",
+		"jobs[0].schedule (line 8): cron: value 25 out of range",
+		"jobs[1].id (line 9): duplicate job id",
+		"jobs[1].task (line 10): unknown task",
+		"jobs[1].overlap (line 12): unknown overlap policy",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) {
+		t.Errorf("error %T does not wrap *Error", err)
+	}
+}
+
+func TestParseRejectsUnknownKeys(t *testing.T) {
+	_, err := Parse([]byte(`
+jobs:
+  - id: scraper
+    intervall: 1h
+`))
+	if err == nil || !strings.Contains(err.Error(), "line 4") || !strings.Contains(err.Error(), "intervall") {
+		t.Errorf("Parse() error = %v, want unknown key on line 4", err)
+	}
+}
+
+func TestParseRejectsDependencyCycles(t *testing.T) {
+	_, err := Parse([]byte(`
+jobs:
+  - id: x
+    task: test-cleanup
+    depends_on: [y]
+  - id: y
+    task: test-cleanup
+    depends_on: [x]
+  - id: z
+    task: test-cleanup
+    depends_on: [z]
+`))
+	for _, want := range []string{
+		"jobs[1].depends_on (line 8): dependency cycle: x -> y -> x",
+		"jobs[2].depends_on (line 11): dependency cycle: z -> z",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse() error = %v, want %q", err, want)
+		}
+	}
+	if !errors.Is(err, agg.ErrDependencyCycle) {
+		t.Errorf("error %v does not wrap ErrDependencyCycle", err)
+	}
+}
+
+func TestStateFileRestoresLastRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs-state.json")
+	lastRun := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	if err := agg.NewFileStore(path).Save(context.Background(), agg.JobState{ID: "cleanup", LastRun: lastRun}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Parse([]byte(`
+aggregator:
+  state_file: ` + path + `
+jobs:
+  - id: cleanup
+    task: test-cleanup
+    interval: 1h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := c.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+	a.Start()
+
+	if next, _ := a.NextRun("cleanup"); !next.Equal(lastRun.Add(time.Hour)) {
+		t.Errorf("NextRun = %v, want an hour after the stored %v", next, lastRun)
+	}
+}
+
+func TestReloadDiffsJobs(t *testing.T) {
+	const base = `
+workers:
+  pracuj:
+    source: pracuj.pl
+jobs:
+  - id: scraper
+    worker: pracuj
+    interval: 1h
+  - id: cleanup
+    task: test-cleanup
+    interval: 24h
+`
+	c, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := c.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+	before, _ := a.NextRun("scraper")
+
+	next, err := Parse([]byte(`
+workers:
+  pracuj:
+    source: pracuj.pl
+jobs:
+  - id: scraper
+    worker: pracuj
+    interval: 2h
+  - id: report
+    task: test-cleanup
+    interval: 1h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(a, next); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, info := range a.ListJobs() {
+		ids = append(ids, info.ID)
+	}
+	if strings.Join(ids, ",") != "report,scraper" {
+		t.Errorf("jobs after reload = %v, want report and scraper", ids)
+	}
+	if after, _ := a.NextRun("scraper"); !after.Equal(before.Add(time.Hour)) {
+		t.Errorf("scraper NextRun = %v, want %v from the kept LastRun", after, before.Add(time.Hour))
+	}
+}
+
+func TestReloadIsAllOrNothing(t *testing.T) {
+	const base = `
+workers:
+  pracuj:
+    source: pracuj.pl
+jobs:
+  - id: scraper
+    worker: pracuj
+    interval: 1h
+  - id: old
+    task: test-cleanup
+    interval: 24h
+    depends_on: [scraper]
+`
+	c, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := c.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	jobIDs := func() string {
+		var ids []string
+		for _, info := range a.ListJobs() {
+			ids = append(ids, info.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+	before, _ := a.NextRun("scraper")
+
+	for name, next := range map[string]*Config{
+		"cycle": {Workers: c.Workers, Jobs: []JobConfig{
+			{ID: "scraper", Worker: "pracuj", Interval: 2 * time.Hour, DependsOn: []string{"x"}},
+			{ID: "x", Task: "test-cleanup", DependsOn: []string{"scraper"}},
+		}},
+		"broken sink": {Workers: c.Workers, Jobs: []JobConfig{
+			{ID: "scraper", Worker: "pracuj", Interval: 2 * time.Hour},
+			{ID: "x", Worker: "pracuj", Interval: time.Hour, Sinks: []SinkConfig{{Type: "file"}}},
+		}},
+	} {
+		if err := c.Reload(a, next); err == nil {
+			t.Errorf("%s: Reload() succeeded, want error", name)
+		}
+		if ids := jobIDs(); ids != "old,scraper" {
+			t.Errorf("%s: jobs after failed reload = %s, want old and scraper", name, ids)
+		}
+		if next, _ := a.NextRun("scraper"); !next.Equal(before) {
+			t.Errorf("%s: scraper NextRun = %v after failed reload, want %v", name, next, before)
+		}
+	}
+
+	// Reversing a dependency only works if the dependency is updated first.
+	next, err := Parse([]byte(`
+workers:
+  pracuj:
+    source: pracuj.pl
+jobs:
+  - id: scraper
+    worker: pracuj
+    interval: 1h
+    depends_on: [old]
+  - id: old
+    task: test-cleanup
+    interval: 24h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(a, next); err != nil {
+		t.Fatalf("Reload() reversing a dependency: %v", err)
+	}
+}
+
+// pagedWorker serves pages of offers with the given sizes.
+type pagedWorker []int
+
+func (w pagedWorker) GetPagesCount(context.Context) (int, error) {
+	return len(w), nil
+}
+
+func (w pagedWorker) GetOffers(_ context.Context, page int) ([]*models.Offer, error) {
+	offers := make([]*models.Offer, w[page-1])
+	for i := range offers {
+		offers[i] = &models.Offer{Title: fmt.Sprintf("offer %d/%d", page, i)}
+	}
+	return offers, nil
+}
+
+type batchSink struct {
+	sizes []int
+}
+
+func (s *batchSink) Write(_ context.Context, _ string, offers []*models.Offer) error {
+	s.sizes = append(s.sizes, len(offers))
+	return nil
+}
+
+func TestScrapeBatchesSinkWrites(t *testing.T) {
+	sink := &batchSink{}
+	if err := scrape("scraper", pagedWorker{40, 40, 40}, []Sink{sink})(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sink.sizes, []int{50, 50, 20}) {
+		t.Errorf("sink writes = %v, want batches of 50, 50 and 20", sink.sizes)
+	}
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const base = `
+jobs:
+  - id: cleanup
+    task: test-cleanup
+    interval: 24h
+`
+	write(base)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := c.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.reloadOn(ctx, a, path, signals)
+	}()
+
+	write(base + `
+  - id: report
+    task: test-cleanup
+    interval: 1h
+`)
+	signals <- syscall.SIGHUP
+	// Each send waits for the previous reload to finish, so the last one
+	// only makes sure the broken file was read.
+	write("jobs: [")
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGHUP
+	cancel()
+	<-done
+
+	if _, exists := a.NextRun("report"); !exists {
+		t.Error("job added to the file was not registered")
+	}
+	if len(c.Jobs) != 2 {
+		t.Errorf("config has %d jobs after reloads, want the 2 of the last valid file", len(c.Jobs))
+	}
+}
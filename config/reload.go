@@ -0,0 +1,120 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	agg "github.com/kabinasoftware/jobs-agg"
+)
+
+// Reload applies next to a, which was built from c. Jobs missing from next
+// are removed, new jobs are added and changed jobs are updated in place,
+// keeping their LastRun; executions already running are not interrupted.
+// Jobs whose definition and worker are unchanged are left alone. Of the
+// aggregator settings only the number of workers is applied; the others
+// require a restart.
+//
+// Only the jobs of c are compared with next. Jobs registered on a in code are
+// left alone, unless next defines a job with the same ID, which then replaces
+// them.
+//
+// next is validated and all of its jobs are built before a is changed, and
+// jobs are only removed once the others were applied, so a failed Reload
+// leaves a as it was built from c.
+func (c *Config) Reload(a *agg.Aggregator, next *Config) error {
+	if err := next.validate(); err != nil {
+		return err
+	}
+
+	prev := make(map[string]JobConfig, len(c.Jobs))
+	for _, jc := range c.Jobs {
+		prev[jc.ID] = jc
+	}
+	changed := func(jc JobConfig) bool {
+		old, exists := prev[jc.ID]
+		return !exists || !old.equal(jc) || !reflect.DeepEqual(c.Workers[old.Worker], next.Workers[jc.Worker])
+	}
+	if err := next.apply(a, changed); err != nil {
+		return err
+	}
+
+	logger := a.Logger()
+	keep := make(map[string]bool, len(next.Jobs))
+	for _, jc := range next.Jobs {
+		keep[jc.ID] = true
+		if _, exists := prev[jc.ID]; !exists {
+			logger.Info("job added", "id", jc.ID)
+		} else if changed(jc) {
+			logger.Info("job updated", "id", jc.ID)
+		}
+	}
+	for _, jc := range c.Jobs {
+		if keep[jc.ID] {
+			continue
+		}
+		if err := a.RemoveJob(jc.ID); err != nil {
+			logger.Warn("failed to remove job", "id", jc.ID, "error", err)
+			continue
+		}
+		logger.Info("job removed", "id", jc.ID)
+	}
+
+	if c.Aggregator.Workers != next.Aggregator.Workers {
+		a.SetWorkers(next.Aggregator.Workers)
+	}
+	settings, nextSettings := c.Aggregator, next.Aggregator
+	settings.Workers, nextSettings.Workers = 0, 0
+	if !reflect.DeepEqual(settings, nextSettings) {
+		logger.Warn("aggregator settings other than workers changed, restart to apply them")
+	}
+	return nil
+}
+
+// ReloadOnSignal reloads the configuration from path into a whenever the
+// process receives one of signals, SIGHUP if none are given, until ctx is
+// done. c must be the configuration a was built from; it is replaced by every
+// configuration applied and must not be used elsewhere meanwhile. A file that
+// fails to load or apply is logged and a keeps its current configuration.
+func (c *Config) ReloadOnSignal(ctx context.Context, a *agg.Aggregator, path string, signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+
+	c.reloadOn(ctx, a, path, ch)
+}
+
+func (c *Config) reloadOn(ctx context.Context, a *agg.Aggregator, path string, signals <-chan os.Signal) {
+	logger := a.Logger()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+
+		next, err := Load(path)
+		if err != nil {
+			logger.Error("failed to reload config, keeping the current one", "path", path, "error", err)
+			continue
+		}
+		if err := c.Reload(a, next); err != nil {
+			logger.Error("failed to apply reloaded config", "path", path, "error", err)
+			continue
+		}
+		*c = *next
+		logger.Info("config reloaded", "path", path)
+	}
+}
+
+// equal reports whether two job configurations define the same job.
+func (jc JobConfig) equal(other JobConfig) bool {
+	// The parsed schedules differ in their time zone pointers only.
+	jc.schedule, other.schedule = nil, nil
+	return reflect.DeepEqual(jc, other)
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/kabinasoftware/jobs-agg/models"
+)
+
+// Sink receives the offers scraped by a job.
+type Sink interface {
+	Write(ctx context.Context, job string, offers []*models.Offer) error
+}
+
+// SinkFactory creates a sink from the parameters given next to its type in
+// the configuration file.
+type SinkFactory func(params map[string]string) (Sink, error)
+
+var (
+	registryMu sync.RWMutex
+	sinks      = make(map[string]SinkFactory)
+	tasks      = make(map[string]func(ctx context.Context) error)
+)
+
+func init() {
+	RegisterSink("log", func(map[string]string) (Sink, error) {
+		return logSink{}, nil
+	})
+	RegisterSink("file", newFileSink)
+}
+
+// RegisterSink makes a sink type available to configuration files. It panics
+// if name is already registered.
+func RegisterSink(name string, factory SinkFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := sinks[name]; exists {
+		panic("config: sink " + name + " registered twice")
+	}
+	sinks[name] = factory
+}
+
+// RegisterTask makes fn available to jobs of configuration files under name.
+// It panics if name is already registered.
+func RegisterTask(name string, fn func(ctx context.Context) error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := tasks[name]; exists {
+		panic("config: task " + name + " registered twice")
+	}
+	tasks[name] = fn
+}
+
+func lookupSink(name string) (SinkFactory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := sinks[name]
+	return factory, ok
+}
+
+func lookupTask(name string) (func(ctx context.Context) error, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := tasks[name]
+	return fn, ok
+}
+
+type logSink struct{}
+
+func (logSink) Write(_ context.Context, job string, offers []*models.Offer) error {
+	for _, offer := range offers {
+		slog.Info("offer", "job", job, "offer", offer)
+	}
+	return nil
+}
+
+// fileSink appends offers as JSON lines to a file.
+type fileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func newFileSink(params map[string]string) (Sink, error) {
+	path := params["path"]
+	if path == "" {
+		return nil, errors.New("file sink requires path")
+	}
+	return &fileSink{path: path}, nil
+}
+
+func (s *fileSink) Write(_ context.Context, _ string, offers []*models.Offer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open offers file: %w", err)
+	}
+
+	enc := json.NewEncoder(f)
+	for _, offer := range offers {
+		if err := enc.Encode(offer); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write offer: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write offers file: %w", err)
+	}
+	return nil
+}
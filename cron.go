@@ -0,0 +1,316 @@
+package agg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type cronField struct {
+	name     string
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	secondField = cronField{name: "second", min: 0, max: 59}
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = cronField{name: "day of week", min: 0, max: 7, names: map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// CronSchedule is a Schedule built from a cron expression. It accepts the
+// standard 5-field syntax (minute hour dom month dow), a 6-field variant with
+// a leading seconds field, the usual @descriptors and an optional
+// CRON_TZ=<zone> (or TZ=<zone>) prefix.
+type CronSchedule struct {
+	spec    string
+	loc     *time.Location
+	second  uint64
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+// ParseCron parses a cron expression evaluated in the local time zone unless
+// the expression carries its own CRON_TZ= prefix.
+func ParseCron(spec string) (*CronSchedule, error) {
+	return ParseCronInLocation(spec, time.Local)
+}
+
+// ParseCronInLocation parses a cron expression evaluated in loc. A CRON_TZ=
+// prefix in the expression takes precedence over loc.
+func ParseCronInLocation(spec string, loc *time.Location) (*CronSchedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	expr := strings.TrimSpace(spec)
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		i := strings.IndexAny(expr, " \t")
+		if i == -1 {
+			return nil, fmt.Errorf("cron: missing expression after time zone in %q", spec)
+		}
+		zone := expr[strings.Index(expr, "=")+1 : i]
+		l, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("cron: invalid time zone %q: %w", zone, err)
+		}
+		loc = l
+		expr = strings.TrimSpace(expr[i:])
+	}
+
+	if strings.HasPrefix(expr, "@") {
+		d, ok := cronDescriptors[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("cron: unknown descriptor %q", expr)
+		}
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron: expected 5 or 6 fields, got %d in %q", len(fields), spec)
+	}
+
+	s := &CronSchedule{spec: spec, loc: loc}
+	var err error
+	if s.second, _, err = secondField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if s.minute, _, err = minuteField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if s.hour, _, err = hourField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if s.dom, s.domStar, err = domField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if s.month, _, err = monthField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if s.dow, s.dowStar, err = dowField.parse(fields[5]); err != nil {
+		return nil, err
+	}
+	// Sunday may be written as 0 or 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+
+	return s, nil
+}
+
+// MustParseCron is like ParseCron but panics on an invalid expression.
+func MustParseCron(spec string) *CronSchedule {
+	s, err := ParseCron(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (f cronField) parse(expr string) (bits uint64, star bool, err error) {
+	for _, part := range strings.Split(expr, ",") {
+		b, s, err := f.parsePart(part)
+		if err != nil {
+			return 0, false, err
+		}
+		bits |= b
+		star = star || s
+	}
+	return bits, star, nil
+}
+
+func (f cronField) parsePart(part string) (uint64, bool, error) {
+	rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+
+	var (
+		lo, hi uint
+		star   bool
+		err    error
+	)
+	switch {
+	case rangeExpr == "*" || rangeExpr == "?":
+		lo, hi, star = f.min, f.max, true
+	case strings.Contains(rangeExpr, "-"):
+		from, to, _ := strings.Cut(rangeExpr, "-")
+		if lo, err = f.value(from); err != nil {
+			return 0, false, err
+		}
+		if hi, err = f.value(to); err != nil {
+			return 0, false, err
+		}
+		// Sunday may also close a weekday range, as in MON-SUN.
+		if f.name == dowField.name && hi == 0 && lo > 0 {
+			hi = 7
+		}
+	default:
+		if lo, err = f.value(rangeExpr); err != nil {
+			return 0, false, err
+		}
+		hi = lo
+		if hasStep {
+			hi = f.max
+		}
+	}
+
+	step := uint(1)
+	if hasStep {
+		n, err := strconv.ParseUint(stepExpr, 10, 8)
+		if err != nil || n == 0 {
+			return 0, false, fmt.Errorf("cron: invalid step %q in %s field", stepExpr, f.name)
+		}
+		step = uint(n)
+		star = false
+	}
+
+	if lo > hi {
+		return 0, false, fmt.Errorf("cron: invalid range %q in %s field", rangeExpr, f.name)
+	}
+
+	var bits uint64
+	for v := lo; v <= hi; v += step {
+		bits |= 1 << v
+	}
+	return bits, star, nil
+}
+
+func (f cronField) value(s string) (uint, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid value %q in %s field", s, f.name)
+	}
+	if uint(n) < f.min || uint(n) > f.max {
+		return 0, fmt.Errorf("cron: value %d out of range [%d-%d] in %s field", n, f.min, f.max, f.name)
+	}
+	return uint(n), nil
+}
+
+// Location returns the time zone the schedule is evaluated in.
+func (s *CronSchedule) Location() *time.Location {
+	return s.loc
+}
+
+func (s *CronSchedule) String() string {
+	return s.spec
+}
+
+// Next returns the first activation time strictly after t, expressed in t's
+// location, or the zero time if none exists within the next five years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(s.loc)
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+
+	added := false
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		// Midnight may not exist on DST transition days.
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(-time.Duration(t.Hour()) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, s.loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.second&(1<<uint(t.Second())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t.In(origLoc)
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,70 @@
+package agg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Skip("time zone data unavailable:", err)
+	}
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"0 6,18 * * *", time.Date(2025, 1, 6, 7, 0, 0, 0, warsaw), time.Date(2025, 1, 6, 18, 0, 0, 0, warsaw)},
+		{"0 6,18 * * *", time.Date(2025, 1, 6, 18, 0, 0, 0, warsaw), time.Date(2025, 1, 7, 6, 0, 0, 0, warsaw)},
+		{"0 3 * * SUN", time.Date(2025, 1, 6, 0, 0, 0, 0, warsaw), time.Date(2025, 1, 12, 3, 0, 0, 0, warsaw)},
+		{"*/15 * * * *", time.Date(2025, 1, 6, 10, 7, 30, 0, warsaw), time.Date(2025, 1, 6, 10, 15, 0, 0, warsaw)},
+		{"30 * * * * *", time.Date(2025, 1, 6, 10, 0, 30, 0, warsaw), time.Date(2025, 1, 6, 10, 1, 30, 0, warsaw)},
+		{"0 9 * * MON-SUN", time.Date(2025, 1, 11, 10, 0, 0, 0, warsaw), time.Date(2025, 1, 12, 9, 0, 0, 0, warsaw)},
+		{"0 0 1,15 * MON", time.Date(2025, 1, 2, 0, 0, 0, 0, warsaw), time.Date(2025, 1, 6, 0, 0, 0, 0, warsaw)},
+		{"@monthly", time.Date(2025, 1, 6, 0, 0, 0, 0, warsaw), time.Date(2025, 2, 1, 0, 0, 0, 0, warsaw)},
+		// 02:30 does not exist on the spring-forward day.
+		{"30 2 * * *", time.Date(2025, 3, 29, 12, 0, 0, 0, warsaw), time.Date(2025, 3, 31, 2, 30, 0, 0, warsaw)},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseCronInLocation(tt.spec, warsaw)
+		if err != nil {
+			t.Fatalf("ParseCronInLocation(%q): %v", tt.spec, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%v) = %v, want %v", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestCronTimeZonePrefix(t *testing.T) {
+	s, err := ParseCron("CRON_TZ=Europe/Warsaw 0 6 * * *")
+	if err != nil {
+		t.Skip("time zone data unavailable:", err)
+	}
+
+	from := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	want := time.Date(2025, 1, 6, 5, 0, 0, 0, time.UTC)
+	if got := s.Next(from); !got.Equal(want) {
+		t.Errorf("Next(%v) = %v, want %v", from, got, want)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"* * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * FOO *",
+		"@fortnightly",
+		"CRON_TZ=Nowhere/City * * * * *",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package agg
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrDependencyCycle = errors.New("dependency cycle")
+
+// DependsOn makes the job run once every listed job has succeeded since the
+// job was last started by its dependencies. A job with dependencies may still
+// have its own schedule; use a zero interval to run it only as part of the
+// chain. Dependencies may be registered before or after the job itself.
+func DependsOn(ids ...string) JobOption {
+	return func(j *Job) {
+		j.DependsOn = append(j.DependsOn, ids...)
+	}
+}
+
+// checkCycle reports an error if registering job would close a dependency
+// cycle. Callers must hold a.mu.
+func (a *Aggregator) checkCycle(job *Job) error {
+	deps := func(id string) []string {
+		if id == job.ID {
+			return job.DependsOn
+		}
+		if other, exists := a.jobs[id]; exists {
+			return other.DependsOn
+		}
+		return nil
+	}
+
+	var (
+		path  []string
+		visit func(id string) bool
+	)
+	done := make(map[string]bool)
+	visit = func(id string) bool {
+		if i := slices.Index(path, id); i >= 0 {
+			path = append(path[i:], id)
+			return true
+		}
+		if done[id] {
+			return false
+		}
+		path = append(path, id)
+		for _, dep := range deps(id) {
+			if visit(dep) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		done[id] = true
+		return false
+	}
+
+	if visit(job.ID) {
+		return fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(path, " -> "))
+	}
+	return nil
+}
+
+// chain starts the jobs waiting on job once all of their dependencies have
+// succeeded. It is called after every successful execution of job.
+func (a *Aggregator) chain(job *Job) {
+	a.mu.Lock()
+	job.successes++
+
+	var ready []*Job
+	for _, dependent := range a.jobs {
+		if !slices.Contains(dependent.DependsOn, job.ID) || dependent.paused || dependent.quarantined {
+			continue
+		}
+		if !a.dependenciesMet(dependent) {
+			continue
+		}
+		for _, id := range dependent.DependsOn {
+			dependent.chained[id] = a.jobs[id].successes
+		}
+		ready = append(ready, dependent)
+	}
+	a.mu.Unlock()
+
+	for _, dependent := range ready {
+		switch a.dispatch(dependent, TriggerDependency) {
+		case dispatchQueueFull:
+			a.logger.Warn("queue is full, dropping dependent run", "id", dependent.ID, "after", job.ID)
+		case dispatchSkipped:
+			a.logger.Warn("job still running, skipping dependent run", "id", dependent.ID, "after", job.ID)
+		}
+	}
+}
+
+// dependenciesMet reports whether every dependency of job is registered and
+// has succeeded since job was last chained. Callers must hold a.mu.
+func (a *Aggregator) dependenciesMet(job *Job) bool {
+	for _, id := range job.DependsOn {
+		dep, exists := a.jobs[id]
+		if !exists || dep.successes <= job.chained[id] {
+			return false
+		}
+	}
+	return true
+}
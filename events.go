@@ -0,0 +1,141 @@
+package agg
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const listenerBufferSize = 256
+
+// EventType identifies a lifecycle event emitted by the Aggregator.
+type EventType string
+
+const (
+	EventJobScheduled EventType = "job_scheduled"
+	EventJobStarted   EventType = "job_started"
+	EventJobSucceeded EventType = "job_succeeded"
+	EventJobFailed    EventType = "job_failed"
+	EventJobSkipped   EventType = "job_skipped"
+	EventQueueFull    EventType = "queue_full"
+	// EventJobQuarantined is emitted when a job is disabled after repeated
+	// panics; see WithQuarantine.
+	EventJobQuarantined EventType = "job_quarantined"
+	// EventJobCancelled is emitted for a run that was cut short without
+	// failing; Run.Result tells why.
+	EventJobCancelled EventType = "job_cancelled"
+)
+
+// Skip reasons carried by EventJobSkipped.
+const (
+	SkipStillRunning = "still_running"
+	SkipCancelled    = "cancelled"
+	SkipMisfire      = "misfire"
+	SkipNotLeader    = "not_leader"
+	// SkipShutdown marks a queued run dropped because the aggregator
+	// stopped before it started.
+	SkipShutdown = "shutdown"
+)
+
+// Event describes something that happened to a job. Run is set for
+// EventJobSucceeded, EventJobFailed and EventJobCancelled, Reason for
+// EventJobSkipped.
+type Event struct {
+	Type    EventType
+	JobID   string
+	Time    time.Time
+	Attempt int
+	Trigger TriggerReason
+	Reason  string
+	Err     error
+	Run     *JobRun
+}
+
+// Listener receives aggregator events. Each listener is served by its own
+// goroutine, so a slow listener never blocks the worker pool; events that do
+// not fit in its buffer are dropped.
+type Listener interface {
+	OnEvent(event Event)
+}
+
+// ListenerFunc adapts a function to the Listener interface.
+type ListenerFunc func(event Event)
+
+func (f ListenerFunc) OnEvent(event Event) {
+	f(event)
+}
+
+type eventBus struct {
+	mu     sync.RWMutex
+	subs   map[*subscription]struct{}
+	closed bool
+	logger *slog.Logger
+}
+
+type subscription struct {
+	listener Listener
+	events   chan Event
+}
+
+func newEventBus(logger *slog.Logger) *eventBus {
+	return &eventBus{subs: make(map[*subscription]struct{}), logger: logger}
+}
+
+func (b *eventBus) subscribe(l Listener) func() {
+	sub := &subscription{
+		listener: l,
+		events:   make(chan Event, listenerBufferSize),
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return func() {}
+	}
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		for event := range sub.events {
+			sub.listener.OnEvent(event)
+		}
+	}()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subs[sub]; ok {
+			delete(b.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// close ends every subscription and ignores later ones. Events already
+// buffered are still delivered.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+}
+
+func (b *eventBus) publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		select {
+		case sub.events <- event:
+		default:
+			b.logger.Warn("event listener is too slow, dropping event",
+				"type", event.Type,
+				"id", event.JobID)
+		}
+	}
+}
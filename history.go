@@ -0,0 +1,117 @@
+package agg
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultHistorySize = 100
+
+// TriggerReason tells why a job execution was started.
+type TriggerReason string
+
+const (
+	TriggerSchedule TriggerReason = "schedule"
+	TriggerManual   TriggerReason = "manual"
+	TriggerRetry    TriggerReason = "retry"
+	// TriggerPending marks a run that was held back by OverlapQueue.
+	TriggerPending TriggerReason = "pending"
+	// TriggerDependency marks a run started because its dependencies
+	// succeeded.
+	TriggerDependency TriggerReason = "dependency"
+	// TriggerCatchUp marks a run for a slot missed earlier; see
+	// MisfireRunAll.
+	TriggerCatchUp TriggerReason = "catch_up"
+)
+
+// JobRun records a single execution attempt of a job.
+type JobRun struct {
+	JobID    string
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+	Result   RunResult
+	Err      error
+	Attempt  int
+	Trigger  TriggerReason
+}
+
+// RunSink receives every JobRun as soon as it is recorded. Record is called
+// from the worker goroutine that executed the job and should return quickly.
+type RunSink interface {
+	Record(run JobRun)
+}
+
+// RunSinkFunc adapts a function to the RunSink interface.
+type RunSinkFunc func(run JobRun)
+
+func (f RunSinkFunc) Record(run JobRun) {
+	f(run)
+}
+
+// history keeps the most recent runs of every job in fixed-size rings.
+type history struct {
+	mu    sync.RWMutex
+	size  int
+	rings map[string]*runRing
+}
+
+type runRing struct {
+	runs []JobRun
+	next int
+}
+
+func newHistory(size int) *history {
+	return &history{
+		size:  size,
+		rings: make(map[string]*runRing),
+	}
+}
+
+func (h *history) add(run JobRun) {
+	if h.size <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ring, ok := h.rings[run.JobID]
+	if !ok {
+		ring = &runRing{runs: make([]JobRun, 0, h.size)}
+		h.rings[run.JobID] = ring
+	}
+
+	if len(ring.runs) < h.size {
+		ring.runs = append(ring.runs, run)
+		return
+	}
+	ring.runs[ring.next] = run
+	ring.next = (ring.next + 1) % h.size
+}
+
+// remove drops the runs of a job.
+func (h *history) remove(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.rings, id)
+}
+
+// list returns the runs of a job, newest first.
+func (h *history) list(id string) []JobRun {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ring, ok := h.rings[id]
+	if !ok {
+		return nil
+	}
+
+	n := len(ring.runs)
+	runs := make([]JobRun, 0, n)
+	for i := 1; i <= n; i++ {
+		runs = append(runs, ring.runs[(ring.next-i+n)%n])
+	}
+	return runs
+}
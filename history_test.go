@@ -0,0 +1,89 @@
+package agg
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHistoryEvictsOldestRuns(t *testing.T) {
+	h := newHistory(3)
+	for attempt := 1; attempt <= 5; attempt++ {
+		h.add(JobRun{JobID: "pracuj", Attempt: attempt})
+	}
+	h.add(JobRun{JobID: "nofluff", Attempt: 1})
+
+	var got []int
+	for _, run := range h.list("pracuj") {
+		got = append(got, run.Attempt)
+	}
+	if want := []int{5, 4, 3}; !slices.Equal(got, want) {
+		t.Errorf("attempts = %v, want %v", got, want)
+	}
+	if got := len(h.list("nofluff")); got != 1 {
+		t.Errorf("len(list(nofluff)) = %d, want 1", got)
+	}
+
+	h.remove("pracuj")
+	if got := h.list("pracuj"); got != nil {
+		t.Errorf("list after remove = %v, want nil", got)
+	}
+}
+
+func TestLastSuccessAndRunSink(t *testing.T) {
+	sink := make(chan JobRun, 10)
+	a, fake := newTestAggregator(t, 1,
+		WithHistorySize(2),
+		WithRunSink(RunSinkFunc(func(run JobRun) {
+			sink <- run
+		})))
+
+	results := make(chan error, 3)
+	a.AddJob("scrape", time.Hour, func(context.Context) error {
+		return <-results
+	}, testEpoch, WithOverlap(OverlapQueue))
+	finished := subscribe(a, EventJobSucceeded, EventJobFailed)
+	a.Start()
+
+	// OverlapQueue holds a run triggered before the previous one has been
+	// released instead of skipping it.
+	run := func(err error) {
+		t.Helper()
+		results <- err
+		if err := a.TriggerNow("scrape"); err != nil {
+			t.Fatal(err)
+		}
+		waitEvent(t, finished)
+		fake.Advance(time.Minute)
+	}
+
+	run(nil)
+	run(errors.New("503 Service Unavailable"))
+	last, ok := a.LastSuccess("scrape")
+	if !ok || !last.Start.Equal(testEpoch) {
+		t.Errorf("LastSuccess() = %v started %s, want run started %s", ok, last.Start, testEpoch)
+	}
+
+	// The successful run is evicted from the ring of two.
+	run(errors.New("503 Service Unavailable"))
+	if _, ok := a.LastSuccess("scrape"); ok {
+		t.Error("LastSuccess() found a run evicted from the history")
+	}
+
+	var got []RunResult
+	for range 3 {
+		got = append(got, (<-sink).Result)
+	}
+	if want := []RunResult{ResultSucceeded, ResultFailed, ResultFailed}; !slices.Equal(got, want) {
+		t.Errorf("sink got %v, want %v", got, want)
+	}
+
+	if err := a.RemoveJob("scrape"); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.History("scrape"); got != nil {
+		t.Errorf("History after RemoveJob = %v, want nil", got)
+	}
+}
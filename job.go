@@ -0,0 +1,310 @@
+package agg
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+type Job struct {
+	ID       string
+	Interval time.Duration
+	Schedule Schedule
+	LastRun  time.Time
+	Retry    *RetryPolicy
+	Overlap  OverlapPolicy
+	// Priority orders queued runs; higher values run first.
+	Priority int
+	// Timeout bounds a single execution. Zero means no limit.
+	Timeout time.Duration
+	// Group is the job's concurrency group; see InGroup.
+	Group string
+	// Jitter delays every scheduled run by a random duration up to Jitter.
+	Jitter time.Duration
+	// JitterPercent delays every scheduled run by up to the given percentage
+	// of the time between two runs. The larger of both jitters applies.
+	JitterPercent float64
+	// InitialDelay postpones the first scheduled run after registration.
+	InitialDelay time.Duration
+	// Misfire decides how missed runs are caught up; see WithMisfire.
+	Misfire      MisfirePolicy
+	MisfireLimit int
+	// DependsOn lists the jobs that must succeed before this one is started
+	// by the chain; see DependsOn.
+	DependsOn []string
+	Execute   func(ctx context.Context) error
+
+	lastResult RunResult
+	lastError  string
+	failures   int
+
+	// offset postpones the first scheduled run and is cleared once the
+	// scheduler has started the job.
+	offset time.Duration
+	// jitter is the random delay drawn for the run due at jitterBase.
+	jitter     time.Duration
+	jitterBase time.Time
+
+	paused      bool
+	removed     bool
+	quarantined bool
+	panics      int
+
+	// successes counts successful executions; chained holds the successes
+	// of each dependency seen when the job was last started by the chain.
+	successes uint64
+	chained   map[string]uint64
+
+	// next is the wake-up time used by the scheduler's heap; index is the
+	// job's position in it, or -1 when it is not scheduled.
+	next  time.Time
+	index int
+
+	// tasks holds the job's queued and running executions, oldest first.
+	tasks   []*task
+	pending bool
+	// backlog holds missed slots still to be run by MisfireRunAll.
+	backlog []time.Time
+}
+
+// JobStatus is the runtime status of a job as reported by ListJobs.
+type JobStatus string
+
+const (
+	StatusIdle    JobStatus = "idle"
+	StatusQueued  JobStatus = "queued"
+	StatusRunning JobStatus = "running"
+	StatusPaused  JobStatus = "paused"
+	// StatusQuarantined marks a job disabled after repeated panics.
+	StatusQuarantined JobStatus = "quarantined"
+)
+
+// JobInfo is a point-in-time snapshot of a registered job.
+type JobInfo struct {
+	ID                  string
+	Status              JobStatus
+	LastRun             time.Time
+	NextRun             time.Time
+	LastResult          RunResult
+	LastError           string
+	ConsecutiveFailures int
+}
+
+// OverlapPolicy decides what happens when a job becomes due while a previous
+// run of it is still queued or running.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip drops the new run.
+	OverlapSkip OverlapPolicy = iota
+	// OverlapQueue keeps a single pending run that starts once the current
+	// one finishes. Further triggers collapse into it.
+	OverlapQueue
+	// OverlapCancelPrevious cancels the running execution and starts the new
+	// one as soon as the previous has returned. The cancelled run is
+	// recorded as ResultCancelled.
+	OverlapCancelPrevious
+)
+
+func (p OverlapPolicy) String() string {
+	switch p {
+	case OverlapSkip:
+		return "skip"
+	case OverlapQueue:
+		return "queue"
+	case OverlapCancelPrevious:
+		return "cancel_previous"
+	default:
+		return "unknown"
+	}
+}
+
+// JobOption customises a job at registration time.
+type JobOption func(*Job)
+
+// WithRetry makes the aggregator retry failed executions of the job according
+// to policy before waiting for the next scheduled run.
+func WithRetry(policy RetryPolicy) JobOption {
+	return func(j *Job) {
+		j.Retry = &policy
+	}
+}
+
+// WithOverlap sets how the job behaves when it is due while still running.
+// The default is OverlapSkip.
+func WithOverlap(policy OverlapPolicy) JobOption {
+	return func(j *Job) {
+		j.Overlap = policy
+	}
+}
+
+// WithPriority sets the job's queue priority. Higher values run first;
+// the default is 0.
+func WithPriority(priority int) JobOption {
+	return func(j *Job) {
+		j.Priority = priority
+	}
+}
+
+// WithTimeout cancels an execution of the job that runs longer than timeout.
+// Timeouts are reported as ResultTimedOut and wrap ErrJobTimeout.
+func WithTimeout(timeout time.Duration) JobOption {
+	return func(j *Job) {
+		j.Timeout = timeout
+	}
+}
+
+// InGroup puts the job in a concurrency group. The aggregator runs at most as
+// many jobs of a group at once as allowed by WithGroupLimit.
+func InGroup(name string) JobOption {
+	return func(j *Job) {
+		j.Group = name
+	}
+}
+
+// WithJitter delays every scheduled run of the job by a random duration of up
+// to d so that jobs due at the same time do not all start at once. The delay
+// does not shift the schedule, as it is left out of the run's LastRun.
+func WithJitter(d time.Duration) JobOption {
+	return func(j *Job) {
+		j.Jitter = d
+	}
+}
+
+// WithJitterPercent is like WithJitter with the upper bound given as a
+// percentage of the job's interval, or of the time between two cron runs.
+func WithJitterPercent(percent float64) JobOption {
+	return func(j *Job) {
+		j.JitterPercent = percent
+	}
+}
+
+// WithInitialDelay postpones the job's first scheduled run by d. A job that
+// is already overdue runs d after it is registered.
+func WithInitialDelay(d time.Duration) JobOption {
+	return func(j *Job) {
+		j.InitialDelay = d
+	}
+}
+
+// NextRun returns the next time the job is due according to its Schedule, or
+// its Interval when no Schedule is set. A zero time means the job never runs.
+func (j *Job) NextRun() time.Time {
+	schedule := j.Schedule
+	if schedule == nil {
+		schedule = Every(j.Interval)
+	}
+	return schedule.Next(j.LastRun)
+}
+
+// delayFor returns the random delay applied to the run due at base. It is
+// drawn once per base time so that rescheduling does not move the run.
+func (j *Job) delayFor(base time.Time) time.Duration {
+	if base.Equal(j.jitterBase) {
+		return j.jitter
+	}
+
+	limit := max(j.Jitter, 0)
+	if j.JitterPercent > 0 {
+		schedule := j.Schedule
+		if schedule == nil {
+			schedule = Every(j.Interval)
+		}
+		if next := schedule.Next(base); !next.IsZero() {
+			limit = max(limit, time.Duration(float64(next.Sub(base))*j.JitterPercent/100))
+		}
+	}
+
+	j.jitterBase = base
+	j.jitter = 0
+	if limit > 0 {
+		j.jitter = rand.N(limit)
+	}
+	return j.jitter
+}
+
+// update replaces j's definition with that of next, keeping j's runtime
+// state. Callers must hold Aggregator.mu.
+func (j *Job) update(next *Job) {
+	j.Interval = next.Interval
+	j.Schedule = next.Schedule
+	j.Retry = next.Retry
+	j.Overlap = next.Overlap
+	j.Priority = next.Priority
+	j.Timeout = next.Timeout
+	j.Group = next.Group
+	j.Jitter = next.Jitter
+	j.JitterPercent = next.JitterPercent
+	j.InitialDelay = next.InitialDelay
+	j.Misfire = next.Misfire
+	j.MisfireLimit = next.MisfireLimit
+	j.Execute = next.Execute
+
+	// Dependencies kept from before retain their progress.
+	for _, id := range next.DependsOn {
+		if seen, ok := j.chained[id]; ok {
+			next.chained[id] = seen
+		}
+	}
+	j.DependsOn = next.DependsOn
+	j.chained = next.chained
+
+	j.jitterBase = time.Time{}
+}
+
+// restore applies a persisted state. A stored LastRun takes precedence over
+// the one the job was added with, which only applies to jobs that never ran.
+func (j *Job) restore(state JobState) {
+	if !state.LastRun.IsZero() {
+		j.LastRun = state.LastRun
+	}
+	j.lastResult = state.LastResult
+	j.lastError = state.LastError
+	j.failures = state.ConsecutiveFailures
+}
+
+func (j *Job) state() JobState {
+	return JobState{
+		ID:                  j.ID,
+		LastRun:             j.LastRun,
+		LastResult:          j.lastResult,
+		LastError:           j.lastError,
+		ConsecutiveFailures: j.failures,
+	}
+}
+
+// task is a single execution of a job, kept across its retry attempts. It
+// runs the job definition captured when it was dispatched, so that updating
+// a job does not affect executions already under way.
+type task struct {
+	job      *Job
+	attempt  int
+	trigger  TriggerReason
+	priority int
+	group    string
+	execute  func(ctx context.Context) error
+	timeout  time.Duration
+	retry    *RetryPolicy
+	// scheduled is the slot the task runs for and missed the slots it
+	// replaces; both are zero for runs not started by the schedule.
+	scheduled time.Time
+	missed    []time.Time
+
+	// seq and index are maintained by runQueue.
+	seq   uint64
+	index int
+
+	// after lists executions cancelled in favour of this one; it starts only
+	// once they have returned.
+	after     []*task
+	cancel    context.CancelFunc
+	cancelled bool
+	done      chan struct{}
+}
+
+func (t *task) triggerReason() TriggerReason {
+	if t.attempt > 1 {
+		return TriggerRetry
+	}
+	return t.trigger
+}
@@ -0,0 +1,122 @@
+package agg
+
+import (
+	"container/heap"
+	"time"
+)
+
+// jobHeap orders scheduled jobs by their next wake-up time. It implements
+// heap.Interface and must only be used with Aggregator.mu held.
+type jobHeap []*Job
+
+func (h jobHeap) Len() int {
+	return len(h)
+}
+
+func (h jobHeap) Less(i, j int) bool {
+	return h[i].next.Before(h[j].next)
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x any) {
+	job := x.(*Job)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *jobHeap) Pop() any {
+	old := *h
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	job.index = -1
+	*h = old[:n-1]
+	return job
+}
+
+// scheduleAt places job in the heap to wake at t, or takes it out when it is
+// paused, quarantined, removed or t is zero. Callers must hold a.mu.
+func (a *Aggregator) scheduleAt(job *Job, t time.Time) {
+	if job.paused || job.quarantined || job.removed || t.IsZero() {
+		if job.index >= 0 {
+			heap.Remove(&a.timeline, job.index)
+		}
+		a.rearm()
+		return
+	}
+
+	job.next = t
+	if job.index >= 0 {
+		heap.Fix(&a.timeline, job.index)
+	} else {
+		heap.Push(&a.timeline, job)
+	}
+	a.rearm()
+}
+
+// reschedule recomputes job's wake-up time from its schedule, including its
+// start offset and jitter. Callers must hold a.mu.
+func (a *Aggregator) reschedule(job *Job) {
+	next := job.NextRun()
+	if next.IsZero() {
+		a.scheduleAt(job, next)
+		return
+	}
+	if job.offset > 0 {
+		next = later(next, a.clock.Now()).Add(job.offset)
+	}
+	a.scheduleAt(job, next.Add(job.delayFor(next)))
+}
+
+// later returns the later of t and u.
+func later(t, u time.Time) time.Time {
+	if t.After(u) {
+		return t
+	}
+	return u
+}
+
+// rearm wakes the scheduler so it recomputes its timer.
+func (a *Aggregator) rearm() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Aggregator) drainWake() {
+	select {
+	case <-a.wake:
+	default:
+	}
+}
+
+// dueJobs pops every job due at now off the heap. The caller is expected to
+// put each of them back with reschedule or scheduleAt.
+func (a *Aggregator) dueJobs(now time.Time) []*Job {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var jobs []*Job
+	for len(a.timeline) > 0 && !a.timeline[0].next.After(now) {
+		jobs = append(jobs, heap.Pop(&a.timeline).(*Job))
+	}
+	return jobs
+}
+
+// untilNext returns how long the scheduler may sleep, capped at
+// the scheduler interval so wall-clock jumps are noticed.
+func (a *Aggregator) untilNext(now time.Time) time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if len(a.timeline) == 0 {
+		return a.schedulerInterval
+	}
+	return min(max(a.timeline[0].next.Sub(now), 0), a.schedulerInterval)
+}
@@ -0,0 +1,144 @@
+package agg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"time"
+)
+
+const defaultLeaseTTL = time.Minute
+
+var ErrLeaseLost = errors.New("job lease lost")
+
+// Locker hands out expiring leases so that a job runs on only one of several
+// aggregator instances sharing the locker. A lease is held per job ID and
+// expires if its holder stops renewing it.
+type Locker interface {
+	// Acquire takes the lease on key for ttl, or extends it if the caller
+	// already holds it. It reports false if another holder owns an
+	// unexpired lease. Errors are treated as temporary: a renewal that fails
+	// is retried until the lease would expire.
+	Acquire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Release gives up the lease on key if the caller holds it.
+	Release(ctx context.Context, key string) error
+}
+
+// WithLocker makes every execution acquire the job's lease from locker first.
+// Runs that do not get it are skipped with SkipNotLeader. The lease is renewed
+// while the job runs and afterwards held until the job's next run is due plus
+// ttl, so the instance that ran a job keeps running it however the schedules
+// of the other instances are aligned. Another instance takes over once the
+// holder shuts down or misses a run by more than ttl.
+func WithLocker(locker Locker, ttl time.Duration) Option {
+	return func(a *Aggregator) {
+		if ttl <= 0 {
+			ttl = defaultLeaseTTL
+		}
+		a.locker = locker
+		a.leaseTTL = ttl
+	}
+}
+
+// defaultOwner identifies this process as a lease holder.
+func defaultOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%08x", host, os.Getpid(), rand.Uint32())
+}
+
+func (a *Aggregator) acquireLease(job *Job) bool {
+	if a.locker == nil {
+		return true
+	}
+
+	ok, err := a.locker.Acquire(a.ctx, job.ID, a.leaseTTL)
+	if err != nil {
+		a.logger.Error("failed to acquire job lease", "id", job.ID, "error", err)
+		return false
+	}
+	return ok
+}
+
+// renewLease extends the lease of job until the returned function is called.
+// If the lease is lost, or cannot be renewed before it expires, the run is
+// cancelled with ErrLeaseLost.
+func (a *Aggregator) renewLease(job *Job, cancel context.CancelCauseFunc) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	ticker := a.clock.NewTicker(a.leaseTTL / 3)
+
+	go func() {
+		defer close(exited)
+		defer ticker.Stop()
+
+		renewed := a.clock.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C():
+			}
+
+			ok, err := a.locker.Acquire(a.ctx, job.ID, a.leaseTTL)
+			switch {
+			case err == nil && ok:
+				renewed = a.clock.Now()
+				continue
+			case err != nil && a.clock.Now().Sub(renewed) < a.leaseTTL:
+				a.logger.Warn("failed to renew job lease", "id", job.ID, "error", err)
+				continue
+			}
+			a.logger.Error("job lease lost, cancelling run", "id", job.ID, "error", err)
+			cancel(ErrLeaseLost)
+			return
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// holdLease keeps the lease of job, if this instance still holds it, until
+// the job's next run is due plus the lease TTL.
+func (a *Aggregator) holdLease(job *Job) {
+	a.mu.RLock()
+	next := a.nextRun(job)
+	a.mu.RUnlock()
+
+	ttl := a.leaseTTL
+	if !next.IsZero() {
+		ttl += max(next.Sub(a.clock.Now()), 0)
+	}
+	if _, err := a.locker.Acquire(context.WithoutCancel(a.ctx), job.ID, ttl); err != nil {
+		a.logger.Error("failed to hold job lease", "id", job.ID, "error", err)
+	}
+}
+
+// releaseLeases hands the leases of all jobs back on shutdown so that another
+// instance can take over without waiting for them to expire.
+func (a *Aggregator) releaseLeases() {
+	if a.locker == nil {
+		return
+	}
+
+	a.mu.RLock()
+	ids := make([]string, 0, len(a.jobs))
+	for id := range a.jobs {
+		ids = append(ids, id)
+	}
+	a.mu.RUnlock()
+
+	ctx := context.WithoutCancel(a.ctx)
+	for _, id := range ids {
+		if err := a.locker.Release(ctx, id); err != nil {
+			a.logger.Error("failed to release job lease", "id", id, "error", err)
+		}
+	}
+}
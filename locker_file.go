@@ -0,0 +1,195 @@
+package agg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// staleGuard is the age after which a guard file left behind by a
+	// crashed process is removed.
+	staleGuard = 10 * time.Second
+	// guardTimeout bounds how long Acquire and Release wait for another
+	// instance to drop the guard file.
+	guardTimeout    = 2 * time.Second
+	maxGuardBackoff = 100 * time.Millisecond
+)
+
+// FileLocker is a Locker keeping one lease file per job in a directory shared
+// by all instances, such as a local or NFS-mounted volume.
+type FileLocker struct {
+	dir   string
+	owner string
+}
+
+type fileLease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewFileLocker returns a locker storing leases in dir. owner identifies this
+// instance; an empty owner is replaced by one derived from the host name and
+// process ID.
+func NewFileLocker(dir, owner string) *FileLocker {
+	if owner == "" {
+		owner = defaultOwner()
+	}
+	return &FileLocker{dir: dir, owner: owner}
+}
+
+func (l *FileLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	path := l.path(key)
+	if err := l.guard(ctx, path); err != nil {
+		return false, err
+	}
+	defer os.Remove(path + ".guard")
+
+	lease, err := l.read(path)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if lease.Owner != "" && lease.Owner != l.owner && now.Before(lease.Expires) {
+		return false, nil
+	}
+
+	if err := l.write(path, fileLease{Owner: l.owner, Expires: now.Add(ttl)}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *FileLocker) Release(ctx context.Context, key string) error {
+	path := l.path(key)
+	if err := l.guard(ctx, path); err != nil {
+		return err
+	}
+	defer os.Remove(path + ".guard")
+
+	lease, err := l.read(path)
+	if err != nil || lease.Owner != l.owner {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove lease file: %w", err)
+	}
+	return nil
+}
+
+func (l *FileLocker) path(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, key)
+	return filepath.Join(l.dir, name+".lock")
+}
+
+// guard creates the guard file serialising access to the lease at path. While
+// another instance holds it, guard retries with a growing backoff and fails
+// once guardTimeout has passed, so a busy guard is never mistaken for a lease
+// held elsewhere.
+func (l *FileLocker) guard(ctx context.Context, path string) error {
+	guard := path + ".guard"
+	deadline := time.Now().Add(guardTimeout)
+	backoff := 5 * time.Millisecond
+	for {
+		f, err := os.OpenFile(guard, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			return f.Close()
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("failed to create lease guard file: %w", err)
+		}
+
+		if info, err := os.Stat(guard); err == nil && staleGuardFile(info) {
+			breakGuard(guard)
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return errors.New("timed out waiting for lease guard file")
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxGuardBackoff)
+	}
+}
+
+// breakGuard removes a stale guard file. The file is moved aside and checked
+// again: if another instance replaced the stale guard in the meantime, the
+// new guard is linked back instead of being deleted.
+func breakGuard(guard string) {
+	aside := fmt.Sprintf("%s.%08x.stale", guard, rand.Uint32())
+	if err := os.Rename(guard, aside); err != nil {
+		return
+	}
+	defer os.Remove(aside)
+
+	if info, err := os.Stat(aside); err == nil && !staleGuardFile(info) {
+		os.Link(aside, guard)
+	}
+}
+
+func staleGuardFile(info fs.FileInfo) bool {
+	return time.Since(info.ModTime()) >= staleGuard
+}
+
+func (l *FileLocker) read(path string) (fileLease, error) {
+	var lease fileLease
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lease, nil
+	}
+	if err != nil {
+		return lease, fmt.Errorf("failed to read lease file: %w", err)
+	}
+	if len(data) == 0 {
+		return lease, nil
+	}
+
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return lease, fmt.Errorf("failed to decode lease file: %w", err)
+	}
+	return lease, nil
+}
+
+func (l *FileLocker) write(path string, lease fileLease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return fmt.Errorf("failed to encode lease: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(l.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create lease file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write lease file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write lease file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace lease file: %w", err)
+	}
+	return nil
+}
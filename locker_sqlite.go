@@ -0,0 +1,66 @@
+package agg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const sqliteLockerSchema = `CREATE TABLE IF NOT EXISTS agg_job_lease (
+	key        TEXT PRIMARY KEY,
+	owner      TEXT NOT NULL,
+	expires_at INTEGER NOT NULL
+)`
+
+// SQLiteLocker is a Locker backed by the agg_job_lease table of a SQLite
+// database shared by all instances. As with SQLiteStore, the caller opens db
+// with the driver of its choice.
+type SQLiteLocker struct {
+	db    *sql.DB
+	owner string
+}
+
+// NewSQLiteLocker creates the lease table if needed and returns a locker
+// using db. An empty owner is replaced by one derived from the host name and
+// process ID.
+func NewSQLiteLocker(ctx context.Context, db *sql.DB, owner string) (*SQLiteLocker, error) {
+	if _, err := db.ExecContext(ctx, sqliteLockerSchema); err != nil {
+		return nil, fmt.Errorf("failed to create job lease table: %w", err)
+	}
+	if owner == "" {
+		owner = defaultOwner()
+	}
+	return &SQLiteLocker{db: db, owner: owner}, nil
+}
+
+func (l *SQLiteLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	res, err := l.db.ExecContext(ctx,
+		`INSERT INTO agg_job_lease (key, owner, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			owner = excluded.owner,
+			expires_at = excluded.expires_at
+		WHERE agg_job_lease.owner = excluded.owner OR agg_job_lease.expires_at <= ?`,
+		key, l.owner, now.Add(ttl).UnixNano(), now.UnixNano())
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire job lease: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire job lease: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (l *SQLiteLocker) Release(ctx context.Context, key string) error {
+	_, err := l.db.ExecContext(ctx,
+		`DELETE FROM agg_job_lease WHERE key = ? AND owner = ?`, key, l.owner)
+	if err != nil {
+		return fmt.Errorf("failed to release job lease: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package agg
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testLockerLease(t *testing.T, first, second Locker) {
+	t.Helper()
+	ctx := context.Background()
+
+	if ok, err := first.Acquire(ctx, "pracuj", time.Hour); err != nil || !ok {
+		t.Fatalf("first Acquire() = %v, %v, want true", ok, err)
+	}
+	if ok, err := second.Acquire(ctx, "pracuj", time.Hour); err != nil || ok {
+		t.Fatalf("second Acquire() = %v, %v, want false while leased", ok, err)
+	}
+	if ok, err := first.Acquire(ctx, "pracuj", time.Hour); err != nil || !ok {
+		t.Fatalf("renewing Acquire() = %v, %v, want true", ok, err)
+	}
+	if ok, err := second.Acquire(ctx, "nofluff", time.Hour); err != nil || !ok {
+		t.Fatalf("Acquire() of another key = %v, %v, want true", ok, err)
+	}
+
+	if err := second.Release(ctx, "pracuj"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := second.Acquire(ctx, "pracuj", time.Hour); ok {
+		t.Fatal("Release by a non-holder freed the lease")
+	}
+	if err := first.Release(ctx, "pracuj"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := second.Acquire(ctx, "pracuj", -time.Second); err != nil || !ok {
+		t.Fatalf("Acquire() after release = %v, %v, want true", ok, err)
+	}
+
+	// second's lease above expired immediately.
+	if ok, err := first.Acquire(ctx, "pracuj", time.Hour); err != nil || !ok {
+		t.Fatalf("Acquire() of expired lease = %v, %v, want true", ok, err)
+	}
+}
+
+func TestFileLockerLease(t *testing.T) {
+	dir := t.TempDir()
+	testLockerLease(t, NewFileLocker(dir, "first"), NewFileLocker(dir, "second"))
+}
+
+func TestSQLiteLockerLease(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var lockers []Locker
+	for _, owner := range []string{"first", "second"} {
+		locker, err := NewSQLiteLocker(context.Background(), db, owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lockers = append(lockers, locker)
+	}
+	testLockerLease(t, lockers[0], lockers[1])
+}
+
+func TestFileLockerWaitsForGuard(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	holder := NewFileLocker(dir, "holder")
+	if ok, err := holder.Acquire(ctx, "pracuj", time.Hour); err != nil || !ok {
+		t.Fatalf("Acquire() = %v, %v, want true", ok, err)
+	}
+
+	// A peer's guard file only delays the renewal.
+	guard := holder.path("pracuj") + ".guard"
+	if err := os.WriteFile(guard, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { os.Remove(guard) })
+	if ok, err := holder.Acquire(ctx, "pracuj", time.Hour); err != nil || !ok {
+		t.Fatalf("renewing Acquire() with a busy guard = %v, %v, want true", ok, err)
+	}
+
+	// A guard that is never released is an error, not a lost lease.
+	if err := os.WriteFile(guard, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if ok, err := holder.Acquire(short, "pracuj", time.Hour); err == nil || ok {
+		t.Fatalf("Acquire() with a stuck guard = %v, %v, want an error", ok, err)
+	}
+}
+
+func TestBreakGuardKeepsReplacedGuard(t *testing.T) {
+	guard := filepath.Join(t.TempDir(), "pracuj.lock.guard")
+	stale := time.Now().Add(-2 * staleGuard)
+
+	// Another instance has replaced the stale guard by the time this one
+	// gets to break it.
+	if err := os.WriteFile(guard, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	breakGuard(guard)
+	if _, err := os.Stat(guard); err != nil {
+		t.Fatalf("a fresh guard was removed: %v", err)
+	}
+
+	if err := os.Chtimes(guard, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	breakGuard(guard)
+	if _, err := os.Stat(guard); !os.IsNotExist(err) {
+		t.Errorf("stale guard was not removed: %v", err)
+	}
+	if matches, _ := filepath.Glob(guard + ".*"); len(matches) > 0 {
+		t.Errorf("left behind %v", matches)
+	}
+}
@@ -0,0 +1,77 @@
+package agg
+
+import "github.com/kabinasoftware/jobs-agg/metrics"
+
+type aggMetrics struct {
+	executed  *metrics.Counter
+	failed    *metrics.Counter
+	timedOut  *metrics.Counter
+	skipped   *metrics.Counter
+	queueFull *metrics.Counter
+	duration  *metrics.Histogram
+	// unregister removes the aggregator's gauge functions from the registry.
+	unregister []func()
+}
+
+// WithMetrics exports scheduler and job metrics to reg. A nil registry uses
+// metrics.Default. Several aggregators may share a registry: the queue depth
+// and worker gauges report their sum, and an aggregator drops out of them once
+// it has stopped.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(a *Aggregator) {
+		if reg == nil {
+			reg = metrics.Default
+		}
+
+		a.metrics = &aggMetrics{
+			executed:  reg.Counter("agg_jobs_executed_total", "Job executions, including retries.", "job"),
+			failed:    reg.Counter("agg_jobs_failed_total", "Failed job executions, including timeouts.", "job"),
+			timedOut:  reg.Counter("agg_jobs_timed_out_total", "Job executions cancelled by their timeout.", "job"),
+			skipped:   reg.Counter("agg_jobs_skipped_total", "Job runs skipped before execution.", "job", "reason"),
+			queueFull: reg.Counter("agg_queue_full_total", "Job runs dropped or evicted because the queue was full.", "job", "reason"),
+			duration:  reg.Histogram("agg_job_duration_seconds", "Duration of job executions.", nil, "job"),
+			unregister: []func(){
+				reg.GaugeFunc("agg_queue_depth", "Number of job runs waiting in the queue.", func() float64 {
+					return float64(a.queue.len())
+				}),
+				reg.GaugeFunc("agg_workers", "Number of workers in the pool.", func() float64 {
+					return float64(a.Workers())
+				}),
+			},
+		}
+	}
+}
+
+// close removes the aggregator from the registry's gauges.
+func (m *aggMetrics) close() {
+	if m == nil {
+		return
+	}
+	for _, unregister := range m.unregister {
+		unregister()
+	}
+}
+
+func (m *aggMetrics) observe(event Event) {
+	if m == nil {
+		return
+	}
+
+	switch event.Type {
+	case EventJobSucceeded, EventJobFailed, EventJobCancelled:
+		m.executed.Inc(event.JobID)
+		if event.Type == EventJobFailed {
+			m.failed.Inc(event.JobID)
+		}
+		if event.Run != nil && event.Run.Result == ResultTimedOut {
+			m.timedOut.Inc(event.JobID)
+		}
+		if event.Run != nil {
+			m.duration.Observe(event.Run.Duration.Seconds(), event.JobID)
+		}
+	case EventJobSkipped:
+		m.skipped.Inc(event.JobID, event.Reason)
+	case EventQueueFull:
+		m.queueFull.Inc(event.JobID, event.Reason)
+	}
+}
@@ -0,0 +1,355 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit job and scrape durations measured in seconds.
+var DefaultBuckets = []float64{0.1, 0.5, 1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+
+// Default is the registry used when no other registry is configured.
+var Default = NewRegistry()
+
+// Handler serves the Default registry in the Prometheus text format.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// Registry holds a set of metrics and renders them in the Prometheus text
+// exposition format. Registering a name twice returns the existing metric.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+	order   []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+type metric struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+	// funcs are the sources of a gauge registered with GaugeFunc; isFunc
+	// stays set once the last of them is unregistered.
+	isFunc bool
+
+	mu     sync.Mutex
+	series map[string]*series
+	funcs  []*gaugeFunc
+}
+
+type gaugeFunc struct {
+	fn func() float64
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func (r *Registry) register(name, help string, typ metricType, labels []string, buckets []float64, isFunc bool) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		if m.typ != typ || m.isFunc != isFunc || !slices.Equal(m.labels, labels) {
+			panic(fmt.Sprintf("metrics: %s already registered with a different type or labels", name))
+		}
+		return m
+	}
+
+	m := &metric{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		isFunc:  isFunc,
+		series:  make(map[string]*series),
+	}
+	r.metrics[name] = m
+	r.order = append(r.order, name)
+	return m
+}
+
+func (m *metric) with(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(values)}
+		if m.typ == typeHistogram {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter is a monotonically increasing metric, optionally split by labels.
+type Counter struct {
+	m *metric
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{m: r.register(name, help, typeCounter, labels, nil, false)}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	s := c.m.with(labelValues)
+	c.m.mu.Lock()
+	s.value += v
+	c.m.mu.Unlock()
+}
+
+// Gauge is a metric that can go up and down, optionally split by labels.
+type Gauge struct {
+	m *metric
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{m: r.register(name, help, typeGauge, labels, nil, false)}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	s := g.m.with(labelValues)
+	g.m.mu.Lock()
+	s.value = v
+	g.m.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	s := g.m.with(labelValues)
+	g.m.mu.Lock()
+	s.value += v
+	g.m.mu.Unlock()
+}
+
+// GaugeFunc registers an unlabelled gauge whose value is read from fn at
+// scrape time. Registering the same name again adds another source, and the
+// gauge reports the sum of all of them. unregister removes fn again.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) (unregister func()) {
+	m := r.register(name, help, typeGauge, nil, nil, true)
+	g := &gaugeFunc{fn: fn}
+
+	m.mu.Lock()
+	m.funcs = append(m.funcs, g)
+	m.mu.Unlock()
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.funcs = slices.DeleteFunc(m.funcs, func(other *gaugeFunc) bool {
+			return other == g
+		})
+	}
+}
+
+// Histogram samples observations into cumulative buckets.
+type Histogram struct {
+	m *metric
+}
+
+// Histogram registers a histogram. A nil buckets slice uses DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &Histogram{m: r.register(name, help, typeHistogram, labels, buckets, false)}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	s := h.m.with(labelValues)
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+
+	for i, upper := range h.m.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := r.WriteTo(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// WriteTo renders every metric in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]*metric, 0, len(r.order))
+	for _, name := range r.order {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		m.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (m *metric) write(w *countingWriter) {
+	if m.help != "" {
+		w.printf("# HELP %s %s\n", m.name, escapeHelp(m.help))
+	}
+	w.printf("# TYPE %s %s\n", m.name, m.typ)
+
+	if m.isFunc {
+		m.mu.Lock()
+		funcs := slices.Clone(m.funcs)
+		m.mu.Unlock()
+
+		// The sources are read without m.mu, as they may take locks of
+		// their own.
+		var sum float64
+		for _, g := range funcs {
+			sum += g.fn()
+		}
+		w.printf("%s %s\n", m.name, formatFloat(sum))
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != typeHistogram {
+			w.printf("%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+
+		for i, upper := range m.buckets {
+			w.printf("%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, formatFloat(upper)), s.counts[i])
+		}
+		w.printf("%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "+Inf"), s.count)
+		w.printf("%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labelValues, ""), formatFloat(s.sum))
+		w.printf("%s_count%s %d\n", m.name, formatLabels(m.labels, s.labelValues, ""), s.count)
+	}
+}
+
+func formatLabels(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if le != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`le="`)
+		b.WriteString(le)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	reg := NewRegistry()
+
+	runs := reg.Counter("agg_runs_total", "Job runs.", "job", "result")
+	runs.Inc("pracuj", "succeeded")
+	runs.Add(2, "nofluff", "failed")
+	runs.Inc("pracuj", "succeeded")
+
+	reg.Counter("agg_plain_total", "").Add(0.5)
+
+	queue := reg.Gauge("agg_queue", "Queued runs.")
+	queue.Set(3)
+	queue.Add(-1)
+
+	reg.GaugeFunc("agg_workers", "Workers in the pool.", func() float64 { return 4 })
+
+	duration := reg.Histogram("agg_duration_seconds", "Run duration.", []float64{5, 1}, "job")
+	duration.Observe(0.5, "pracuj")
+	duration.Observe(3, "pracuj")
+	duration.Observe(60, "pracuj")
+
+	escaped := reg.Counter("agg_escaped_total", "Help with a \\ and a\nnewline.", "error")
+	escaped.Inc("say \"hi\"\\\n")
+
+	var b strings.Builder
+	n, err := reg.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP agg_runs_total Job runs.
+# TYPE agg_runs_total counter
+agg_runs_total{job="nofluff",result="failed"} 2
+agg_runs_total{job="pracuj",result="succeeded"} 2
+# TYPE agg_plain_total counter
+agg_plain_total 0.5
+# HELP agg_queue Queued runs.
+# TYPE agg_queue gauge
+agg_queue 2
+# HELP agg_workers Workers in the pool.
+# TYPE agg_workers gauge
+agg_workers 4
+# HELP agg_duration_seconds Run duration.
+# TYPE agg_duration_seconds histogram
+agg_duration_seconds_bucket{job="pracuj",le="1"} 1
+agg_duration_seconds_bucket{job="pracuj",le="5"} 2
+agg_duration_seconds_bucket{job="pracuj",le="+Inf"} 3
+agg_duration_seconds_sum{job="pracuj"} 63.5
+agg_duration_seconds_count{job="pracuj"} 3
+# HELP agg_escaped_total Help with a \\ and a\nnewline.
+# TYPE agg_escaped_total counter
+agg_escaped_total{error="say \"hi\"\\\n"} 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("WriteTo() wrote\n%s\nwant\n%s", got, want)
+	}
+	if n != int64(len(want)) {
+		t.Errorf("WriteTo() = %d, want %d", n, len(want))
+	}
+}
+
+func TestUnlabelledHistogram(t *testing.T) {
+	reg := NewRegistry()
+	reg.Histogram("agg_scrape_seconds", "", []float64{1}).Observe(2)
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# TYPE agg_scrape_seconds histogram
+agg_scrape_seconds_bucket{le="1"} 0
+agg_scrape_seconds_bucket{le="+Inf"} 1
+agg_scrape_seconds_sum 2
+agg_scrape_seconds_count 1
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("Handler() served\n%s\nwant\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestRegisterConflicts(t *testing.T) {
+	reg := NewRegistry()
+	if reg.Counter("agg_runs_total", "", "job").m != reg.Counter("agg_runs_total", "", "job").m {
+		t.Error("registering a counter twice did not return the existing one")
+	}
+
+	reg.GaugeFunc("agg_workers", "", func() float64 { return 1 })
+	for name, register := range map[string]func(){
+		"gauge over gauge func":   func() { reg.Gauge("agg_workers", "") },
+		"gauge func over counter": func() { reg.GaugeFunc("agg_runs_total", "", func() float64 { return 2 }) },
+		"different labels":        func() { reg.Counter("agg_runs_total", "", "job", "result") },
+		"wrong label value count": func() { reg.Counter("agg_runs_total", "", "job").Inc() },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+func TestGaugeFuncSumsSources(t *testing.T) {
+	reg := NewRegistry()
+	reg.GaugeFunc("agg_workers", "", func() float64 { return 4 })
+	unregister := reg.GaugeFunc("agg_workers", "", func() float64 { return 2 })
+
+	for _, want := range []string{"agg_workers 6\n", "agg_workers 4\n"} {
+		var b strings.Builder
+		if _, err := reg.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(b.String(), want) {
+			t.Errorf("got:\n%s\nwant it to end with %q", b.String(), want)
+		}
+		unregister()
+	}
+}
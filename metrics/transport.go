@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// InstrumentClient returns a copy of client whose requests are counted in
+// the scraper_http_requests_total metric of reg under the given source. The
+// original client is left untouched, so sharing http.DefaultClient is safe.
+func InstrumentClient(client *http.Client, reg *Registry, source string) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if reg == nil {
+		reg = Default
+	}
+
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	instrumented := *client
+	instrumented.Transport = &transport{
+		next:     next,
+		source:   source,
+		requests: reg.Counter("scraper_http_requests_total", "HTTP requests sent by scrapers.", "source", "code"),
+		duration: reg.Histogram("scraper_http_request_duration_seconds", "Duration of scraper HTTP requests.", nil, "source"),
+	}
+	return &instrumented
+}
+
+type transport struct {
+	next     http.RoundTripper
+	source   string
+	requests *Counter
+	duration *Histogram
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.duration.Observe(time.Since(start).Seconds(), t.source)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	t.requests.Inc(t.source, code)
+
+	return resp, err
+}
+
+// OffersProduced counts offers emitted by a scraper in the
+// scraper_offers_total metric of reg.
+func OffersProduced(reg *Registry, source string, n int) {
+	if reg == nil {
+		reg = Default
+	}
+	reg.Counter("scraper_offers_total", "Offers produced by scrapers.", "source").Add(float64(n), source)
+}
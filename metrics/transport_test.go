@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	reg := NewRegistry()
+	client := &http.Client{}
+	instrumented := InstrumentClient(client, reg, "pracuj")
+	if client.Transport != nil {
+		t.Error("InstrumentClient modified the original client")
+	}
+
+	for _, path := range []string{"/", "/", "/missing"} {
+		resp, err := instrumented.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := instrumented.Get("http://127.0.0.1:0/"); err == nil {
+		t.Error("request to an invalid address succeeded")
+	}
+	OffersProduced(reg, "pracuj", 20)
+	OffersProduced(reg, "pracuj", 5)
+
+	var b strings.Builder
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`scraper_http_requests_total{source="pracuj",code="200"} 2`,
+		`scraper_http_requests_total{source="pracuj",code="404"} 1`,
+		`scraper_http_requests_total{source="pracuj",code="error"} 1`,
+		`scraper_http_request_duration_seconds_count{source="pracuj"} 4`,
+		`scraper_offers_total{source="pracuj"} 25`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("output is missing %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package agg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kabinasoftware/jobs-agg/metrics"
+)
+
+func TestMetricsSharedBetweenAggregators(t *testing.T) {
+	reg := metrics.NewRegistry()
+	a, _ := newTestAggregator(t, 1, WithMetrics(reg))
+	b, _ := newTestAggregator(t, 2, WithMetrics(reg))
+
+	a.AddJob("ok", time.Hour, noop, testEpoch)
+	b.AddJob("broken", time.Hour, func(context.Context) error {
+		return errors.New("503 Service Unavailable")
+	}, testEpoch)
+	finished := subscribe(a, EventJobSucceeded)
+	failed := subscribe(b, EventJobFailed)
+	a.Start()
+	b.Start()
+	if err := a.TriggerNow("ok"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.TriggerNow("broken"); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, finished)
+	waitEvent(t, failed)
+
+	var out strings.Builder
+	if _, err := reg.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`agg_jobs_executed_total{job="ok"} 1`,
+		`agg_jobs_executed_total{job="broken"} 1`,
+		`agg_jobs_failed_total{job="broken"} 1`,
+		`agg_job_duration_seconds_count{job="ok"} 1`,
+		`agg_queue_depth 0`,
+		`agg_workers 3`,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("output is missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `agg_jobs_failed_total{job="ok"}`) {
+		t.Errorf("successful run counted as a failure:\n%s", out.String())
+	}
+}
@@ -0,0 +1,164 @@
+package agg
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// misfireThreshold is how late a run may start before MisfireSkip
+	// treats it as missed.
+	misfireThreshold = time.Minute
+	// maxMissedRuns bounds how many missed slots are remembered per run.
+	maxMissedRuns = 100
+)
+
+// MisfirePolicy decides what happens to runs missed while the aggregator was
+// not running or could not keep up.
+type MisfirePolicy int
+
+const (
+	// MisfireRunOnce starts a single run for the latest missed slot.
+	MisfireRunOnce MisfirePolicy = iota
+	// MisfireRunAll starts one run per missed slot, oldest first, up to the
+	// configured limit. Slots beyond the limit are dropped, oldest first.
+	MisfireRunAll
+	// MisfireSkip drops the missed runs and waits for the next slot.
+	MisfireSkip
+)
+
+func (p MisfirePolicy) String() string {
+	switch p {
+	case MisfireRunOnce:
+		return "run_once"
+	case MisfireRunAll:
+		return "run_all"
+	case MisfireSkip:
+		return "skip"
+	default:
+		return "unknown"
+	}
+}
+
+// WithMisfire sets how the job catches up on missed runs. limit caps the
+// number of runs started by MisfireRunAll; zero means maxMissedRuns.
+func WithMisfire(policy MisfirePolicy, limit int) JobOption {
+	return func(j *Job) {
+		j.Misfire = policy
+		j.MisfireLimit = limit
+	}
+}
+
+// elapsedSlots returns the slots of job's schedule due at or before now,
+// oldest first. At most maxMissedRuns+1 of the latest slots are kept. A job
+// that never ran has no missed slots. Callers must hold Aggregator.mu.
+func (j *Job) elapsedSlots(now time.Time) []time.Time {
+	if j.LastRun.IsZero() {
+		return nil
+	}
+
+	schedule := j.Schedule
+	if schedule == nil {
+		schedule = Every(j.Interval)
+	}
+
+	from := j.LastRun
+	if s, ok := schedule.(intervalSchedule); ok && s.interval > 0 {
+		// Interval slots are evenly spaced, so the ones that would be
+		// dropped below are skipped without walking them.
+		if n := now.Sub(from) / s.interval; n > maxMissedRuns+1 {
+			from = from.Add((n - maxMissedRuns - 1) * s.interval)
+		}
+	}
+
+	var slots []time.Time
+	for slot := schedule.Next(from); !slot.IsZero() && !slot.After(now); slot = schedule.Next(slot) {
+		if len(slots) > maxMissedRuns {
+			slots = slots[1:]
+		}
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+// dispatchDue starts the runs of a job popped off the timeline according to
+// its misfire policy.
+func (a *Aggregator) dispatchDue(job *Job, now time.Time) dispatchResult {
+	a.mu.Lock()
+	slots := job.elapsedSlots(now)
+	late := now.Sub(job.next) > misfireThreshold
+	policy, limit := job.Misfire, job.MisfireLimit
+	a.mu.Unlock()
+
+	if len(slots) == 0 {
+		slots = []time.Time{now}
+	}
+
+	switch policy {
+	case MisfireSkip:
+		if len(slots) > 1 || late {
+			a.emit(Event{
+				Type:    EventJobSkipped,
+				JobID:   job.ID,
+				Trigger: TriggerSchedule,
+				Reason:  SkipMisfire,
+			})
+			return dispatchMisfired
+		}
+	case MisfireRunAll:
+		if limit <= 0 {
+			limit = maxMissedRuns
+		}
+		var missed []time.Time
+		if len(slots) > limit {
+			missed, slots = slots[:len(slots)-limit], slots[len(slots)-limit:]
+		}
+
+		// The backlog is registered first so that a run finishing right
+		// away already picks it up.
+		a.mu.Lock()
+		backlog := len(job.backlog)
+		job.backlog = append(job.backlog, slots[1:]...)
+		a.mu.Unlock()
+
+		result := a.dispatchAt(job, TriggerSchedule, slots[0], missed)
+		if result == dispatchQueueFull {
+			a.mu.Lock()
+			job.backlog = job.backlog[:backlog]
+			a.mu.Unlock()
+		}
+		return result
+	}
+
+	last := len(slots) - 1
+	return a.dispatchAt(job, TriggerSchedule, slots[last], slots[:last])
+}
+
+type scheduleKey struct{}
+
+type scheduleInfo struct {
+	scheduled time.Time
+	missed    []time.Time
+}
+
+func withSchedule(ctx context.Context, t *task) context.Context {
+	if t.scheduled.IsZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, scheduleKey{}, scheduleInfo{scheduled: t.scheduled, missed: t.missed})
+}
+
+// ScheduledTime returns the schedule slot the job execution that owns ctx was
+// started for. It returns the zero time for runs not started by the
+// schedule, such as manual or dependency runs.
+func ScheduledTime(ctx context.Context) time.Time {
+	info, _ := ctx.Value(scheduleKey{}).(scheduleInfo)
+	return info.scheduled
+}
+
+// MissedRuns returns the slots skipped in favour of the job execution that
+// owns ctx, oldest first, so that the job can backfill them.
+func MissedRuns(ctx context.Context) []time.Time {
+	info, _ := ctx.Value(scheduleKey{}).(scheduleInfo)
+	return info.missed
+}
@@ -0,0 +1,116 @@
+package agg
+
+import "sync"
+
+// workerPool tracks the worker goroutines. Workers are never stopped from
+// outside: shrinking the pool marks some of them as retiring and each
+// retiring worker exits before picking up its next run.
+type workerPool struct {
+	mu sync.Mutex
+	// size is the configured number of workers and max the auto-scaling
+	// bound, zero when auto-scaling is off.
+	size int
+	max  int
+	// live counts the workers that are not retiring, busy those executing
+	// a run.
+	live     int
+	busy     int
+	retiring int
+	started  bool
+	// changed is closed and replaced whenever workers should re-check
+	// whether to retire.
+	changed chan struct{}
+}
+
+// WithAutoScale lets the aggregator add workers, up to max in total, while
+// runs are waiting in the queue and every worker is busy. The extra workers
+// exit again once the queue is empty.
+func WithAutoScale(max int) Option {
+	return func(a *Aggregator) {
+		a.pool.max = max
+	}
+}
+
+// SetWorkers grows or shrinks the worker pool to n workers. When shrinking,
+// busy workers finish their current run before exiting. It may be called
+// before or after Start.
+func (a *Aggregator) SetWorkers(n int) {
+	a.pool.mu.Lock()
+	defer a.pool.mu.Unlock()
+
+	a.pool.size = max(n, 0)
+	if a.pool.started && !a.stopping() {
+		a.resize(a.pool.size)
+	}
+}
+
+// Workers returns the number of workers currently in the pool.
+func (a *Aggregator) Workers() int {
+	a.pool.mu.Lock()
+	defer a.pool.mu.Unlock()
+	return a.pool.live
+}
+
+// resize starts or retires workers until n remain. Callers must hold
+// a.pool.mu.
+func (a *Aggregator) resize(n int) {
+	for a.pool.live < n {
+		if a.pool.retiring > 0 {
+			a.pool.retiring--
+		} else {
+			a.wg.Add(1)
+			go a.worker()
+		}
+		a.pool.live++
+	}
+
+	if a.pool.live > n {
+		a.pool.retiring += a.pool.live - n
+		a.pool.live = n
+		close(a.pool.changed)
+		a.pool.changed = make(chan struct{})
+	}
+}
+
+// scaleUp adds a worker if auto-scaling is enabled, runs are waiting and all
+// workers are busy.
+func (a *Aggregator) scaleUp() {
+	a.pool.mu.Lock()
+	defer a.pool.mu.Unlock()
+
+	if a.pool.max == 0 || !a.pool.started || a.stopping() {
+		return
+	}
+	if a.pool.live < a.pool.max && a.pool.busy >= a.pool.live && a.queue.len() > 0 {
+		a.resize(a.pool.live + 1)
+	}
+}
+
+// retire reports whether the calling worker should exit. An idle worker also
+// exits when it was added by auto-scaling.
+func (a *Aggregator) retire(idle bool) bool {
+	a.pool.mu.Lock()
+	defer a.pool.mu.Unlock()
+
+	if a.pool.retiring > 0 {
+		a.pool.retiring--
+		return true
+	}
+	if idle && a.pool.live > a.pool.size {
+		a.pool.live--
+		return true
+	}
+	return false
+}
+
+func (a *Aggregator) setBusy(delta int) {
+	a.pool.mu.Lock()
+	a.pool.busy += delta
+	a.pool.mu.Unlock()
+}
+
+func (a *Aggregator) poolChanged() <-chan struct{} {
+	a.pool.mu.Lock()
+	defer a.pool.mu.Unlock()
+	return a.pool.changed
+}
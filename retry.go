@@ -0,0 +1,84 @@
+package agg
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+const defaultRetryBaseDelay = time.Second
+
+// RetryPolicy controls how a failed job is retried before the aggregator falls
+// back to its regular schedule.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry; it doubles for every
+	// following attempt. Defaults to one second.
+	BaseDelay time.Duration
+	// MaxDelay caps the computed delay. Zero means no cap.
+	MaxDelay time.Duration
+	// Jitter randomises each delay by up to the given fraction (0.0-1.0) in
+	// either direction.
+	Jitter float64
+	// Retryable decides whether an error is worth retrying. A nil Retryable
+	// retries every error.
+	Retryable func(err error) bool
+}
+
+func (p *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil && !p.Retryable(err) {
+		return false
+	}
+	return true
+}
+
+// delay returns how long to wait after the given failed attempt.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+
+	d := base
+	for i := 1; i < attempt; i++ {
+		// Stop doubling before d, plus up to d of jitter, can overflow.
+		if d > math.MaxInt64/4 {
+			break
+		}
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		jitter := min(p.Jitter, 1)
+		d += time.Duration((rand.Float64()*2 - 1) * jitter * float64(d))
+	}
+
+	return d
+}
+
+type attemptKey struct{}
+
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// Attempt returns the 1-based attempt number of the job execution that owns
+// ctx. It returns 1 for contexts not created by the aggregator.
+func Attempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
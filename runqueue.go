@@ -0,0 +1,268 @@
+package agg
+
+import (
+	"container/heap"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kabinasoftware/jobs-agg/clock"
+)
+
+// OverflowPolicy decides what happens when a run is dispatched while the
+// queue is at capacity.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop discards the new run.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock waits for free space up to the configured timeout and
+	// then discards the new run.
+	OverflowBlock
+	// OverflowEvictLowest discards the queued run with the lowest priority
+	// (the newest among equals) if the new run has a higher priority, and
+	// the new run otherwise.
+	OverflowEvictLowest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDrop:
+		return "drop"
+	case OverflowBlock:
+		return "block"
+	case OverflowEvictLowest:
+		return "evict_lowest"
+	default:
+		return "unknown"
+	}
+}
+
+// Overflow reasons carried by EventQueueFull.
+const (
+	OverflowDropped  = "dropped"
+	OverflowTimedOut = "timeout"
+	OverflowEvicted  = "evicted"
+)
+
+var (
+	errQueueTimeout = errors.New("timed out waiting for queue space")
+	errQueueClosed  = errors.New("aggregator is stopping")
+)
+
+// runQueue is a bounded priority queue of tasks. Higher priorities are
+// popped first and tasks of equal priority keep their FIFO order. Tasks whose
+// concurrency group is at its limit stay queued until a slot is released.
+type runQueue struct {
+	mu    sync.Mutex
+	items taskHeap
+	size  int
+	seq   uint64
+	// limits caps the running tasks per concurrency group; active counts
+	// the tasks popped and not yet released.
+	limits map[string]int
+	active map[string]int
+	// ready and space hold at most one pending signal each; consumers
+	// re-signal when more work or room remains.
+	ready chan struct{}
+	space chan struct{}
+}
+
+func newRunQueue(size int) *runQueue {
+	return &runQueue{
+		size:   size,
+		limits: make(map[string]int),
+		active: make(map[string]int),
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+func (q *runQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// push adds t according to policy. It returns the task evicted to make room,
+// if any.
+func (q *runQueue) push(t *task, policy OverflowPolicy, timeout time.Duration, clk clock.Clock, done <-chan struct{}) (*task, error) {
+	var deadline clock.Timer
+
+	for {
+		q.mu.Lock()
+		if len(q.items) < q.size {
+			q.insert(t)
+			q.mu.Unlock()
+			return nil, nil
+		}
+
+		switch policy {
+		case OverflowEvictLowest:
+			lowest := q.lowest()
+			if lowest == nil || lowest.priority >= t.priority {
+				q.mu.Unlock()
+				return nil, ErrQueueFull
+			}
+			heap.Remove(&q.items, lowest.index)
+			q.insert(t)
+			q.mu.Unlock()
+			return lowest, nil
+		case OverflowBlock:
+			q.mu.Unlock()
+			if deadline == nil {
+				deadline = clk.NewTimer(timeout)
+				defer deadline.Stop()
+			}
+			select {
+			case <-q.space:
+			case <-deadline.C():
+				return nil, errQueueTimeout
+			case <-done:
+				return nil, errQueueClosed
+			}
+		default:
+			q.mu.Unlock()
+			return nil, ErrQueueFull
+		}
+	}
+}
+
+// insert adds t to the heap and signals a waiting worker. Callers must hold
+// q.mu.
+func (q *runQueue) insert(t *task) {
+	q.seq++
+	t.seq = q.seq
+	heap.Push(&q.items, t)
+	signal(q.ready)
+}
+
+// pop removes the highest-priority task whose concurrency group has a free
+// slot without blocking. The slot is held until release is called.
+func (q *runQueue) pop() (*task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var next *task
+	for _, t := range q.items {
+		if q.available(t.group) && (next == nil || q.items.Less(t.index, next.index)) {
+			next = t
+		}
+	}
+	if next == nil {
+		return nil, false
+	}
+	heap.Remove(&q.items, next.index)
+	if next.group != "" {
+		q.active[next.group]++
+	}
+	signal(q.space)
+	if len(q.items) > 0 {
+		signal(q.ready)
+	}
+	return next, true
+}
+
+// drain removes and returns every queued task.
+func (q *runQueue) drain() []*task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tasks := slices.Clone(q.items)
+	for _, t := range tasks {
+		t.index = -1
+	}
+	q.items = nil
+	signal(q.space)
+	return tasks
+}
+
+// release frees the group slot taken when t was popped.
+func (q *runQueue) release(t *task) {
+	group := t.group
+	if group == "" {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.active[group]--
+	if len(q.items) > 0 {
+		signal(q.ready)
+	}
+}
+
+// setLimit caps the tasks of group running at once. A limit below 1 removes
+// the cap.
+func (q *runQueue) setLimit(group string, n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n < 1 {
+		delete(q.limits, group)
+	} else {
+		q.limits[group] = n
+	}
+	signal(q.ready)
+}
+
+// available reports whether group has a free slot. Callers must hold q.mu.
+func (q *runQueue) available(group string) bool {
+	limit, ok := q.limits[group]
+	return !ok || q.active[group] < limit
+}
+
+func (q *runQueue) lowest() *task {
+	var lowest *task
+	for _, t := range q.items {
+		if lowest == nil || t.priority < lowest.priority ||
+			(t.priority == lowest.priority && t.seq > lowest.seq) {
+			lowest = t
+		}
+	}
+	return lowest
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+type taskHeap []*task
+
+func (h taskHeap) Len() int {
+	return len(h)
+}
+
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *taskHeap) Push(x any) {
+	t := x.(*task)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *taskHeap) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
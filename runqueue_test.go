@@ -0,0 +1,147 @@
+package agg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kabinasoftware/jobs-agg/clock"
+)
+
+func newTestTask(id string, priority int) *task {
+	return &task{job: &Job{ID: id}, priority: priority, attempt: 1, index: -1}
+}
+
+func TestRunQueuePopsByPriorityThenFIFO(t *testing.T) {
+	q := newRunQueue(10)
+	fake := clock.NewFake(testEpoch)
+	for _, tt := range []*task{
+		newTestTask("cleanup", -1),
+		newTestTask("pracuj", 10),
+		newTestTask("dedup", 0),
+		newTestTask("nofluff", 10),
+	} {
+		if _, err := q.push(tt, OverflowDrop, 0, fake, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for {
+		tt, ok := q.pop()
+		if !ok {
+			break
+		}
+		got = append(got, tt.job.ID)
+	}
+
+	want := []string{"pracuj", "nofluff", "dedup", "cleanup"}
+	if len(got) != len(want) {
+		t.Fatalf("popped %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("popped %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRunQueueOverflowDrop(t *testing.T) {
+	q := newRunQueue(1)
+	fake := clock.NewFake(testEpoch)
+	q.push(newTestTask("first", 0), OverflowDrop, 0, fake, nil)
+
+	if _, err := q.push(newTestTask("second", 5), OverflowDrop, 0, fake, nil); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("push on full queue = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+func TestRunQueueOverflowEvictLowest(t *testing.T) {
+	q := newRunQueue(2)
+	fake := clock.NewFake(testEpoch)
+	q.push(newTestTask("cleanup", -1), OverflowEvictLowest, 0, fake, nil)
+	q.push(newTestTask("dedup", 0), OverflowEvictLowest, 0, fake, nil)
+
+	evicted, err := q.push(newTestTask("pracuj", 10), OverflowEvictLowest, 0, fake, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evicted == nil || evicted.job.ID != "cleanup" {
+		t.Fatalf("evicted %v, want cleanup", evicted)
+	}
+
+	// A run that does not outrank anything queued is dropped instead.
+	if _, err := q.push(newTestTask("stats", 0), OverflowEvictLowest, 0, fake, nil); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("push of equal priority = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+func TestRunQueueOverflowBlock(t *testing.T) {
+	q := newRunQueue(1)
+	fake := clock.NewFake(testEpoch)
+	q.push(newTestTask("first", 0), OverflowBlock, 0, fake, nil)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := q.push(newTestTask("second", 0), OverflowBlock, time.Minute, fake, nil)
+		result <- err
+	}()
+	fake.BlockUntil(1)
+	q.pop()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("blocked push = %v, want success once space frees up", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked push did not resume")
+	}
+
+	go func() {
+		_, err := q.push(newTestTask("third", 0), OverflowBlock, time.Minute, fake, nil)
+		result <- err
+	}()
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, errQueueTimeout) {
+			t.Fatalf("blocked push = %v, want %v", err, errQueueTimeout)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked push did not time out")
+	}
+}
+
+func TestRunQueueGroupLimit(t *testing.T) {
+	q := newRunQueue(10)
+	q.setLimit("pracuj.pl", 1)
+	fake := clock.NewFake(testEpoch)
+
+	first := newTestTask("pracuj-it", 10)
+	first.group = "pracuj.pl"
+	second := newTestTask("pracuj-sales", 10)
+	second.group = "pracuj.pl"
+	for _, tt := range []*task{first, second, newTestTask("nofluff", 0)} {
+		if _, err := q.push(tt, OverflowDrop, 0, fake, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if tt, _ := q.pop(); tt != first {
+		t.Fatal("first pop did not return pracuj-it")
+	}
+	if tt, _ := q.pop(); tt == nil || tt.job.ID != "nofluff" {
+		t.Fatal("second pop did not return nofluff while the group is full")
+	}
+	if tt, ok := q.pop(); ok {
+		t.Fatalf("pop returned %q while the group is full", tt.job.ID)
+	}
+
+	q.release(first)
+	if tt, _ := q.pop(); tt != second {
+		t.Fatal("pop after release did not return pracuj-sales")
+	}
+}
@@ -0,0 +1,25 @@
+package agg
+
+import "time"
+
+// Schedule describes when a job should run. Next returns the first activation
+// time strictly after t, or the zero time if the schedule never fires again.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+// Every returns a schedule that fires at a fixed interval after the last run.
+func Every(interval time.Duration) Schedule {
+	return intervalSchedule{interval: interval}
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	if s.interval <= 0 {
+		return time.Time{}
+	}
+	return t.Add(s.interval)
+}
@@ -0,0 +1,48 @@
+package agg
+
+import (
+	"context"
+	"time"
+)
+
+// RunResult is the outcome of a single job execution.
+type RunResult string
+
+const (
+	ResultSucceeded RunResult = "succeeded"
+	ResultFailed    RunResult = "failed"
+	// ResultTimedOut is a failure caused by the job exceeding its Timeout.
+	ResultTimedOut RunResult = "timed_out"
+	// ResultPanicked is a failure caused by a panic inside the job.
+	ResultPanicked RunResult = "panicked"
+	// ResultInterrupted marks a run cut short because the aggregator was
+	// stopped. It does not count as a failure.
+	ResultInterrupted RunResult = "interrupted"
+	// ResultCancelled marks a run cancelled in favour of a newer one; see
+	// OverlapCancelPrevious. It does not count as a failure.
+	ResultCancelled RunResult = "cancelled"
+)
+
+// cutShort reports whether r is a run stopped from outside the job, which
+// neither failed nor succeeded.
+func (r RunResult) cutShort() bool {
+	return r == ResultInterrupted || r == ResultCancelled
+}
+
+// JobState is the part of a job's runtime state that is persisted across
+// restarts.
+type JobState struct {
+	ID                  string    `json:"id"`
+	LastRun             time.Time `json:"last_run"`
+	LastResult          RunResult `json:"last_result,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// JobStore persists JobState so that schedules survive process restarts. The
+// aggregator loads all states on Start and saves a job's state after each of
+// its executions.
+type JobStore interface {
+	Load(ctx context.Context) (map[string]JobState, error)
+	Save(ctx context.Context, state JobState) error
+}
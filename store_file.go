@@ -0,0 +1,98 @@
+package agg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore is a JobStore keeping every job's state in a single JSON file.
+type FileStore struct {
+	path   string
+	mu     sync.Mutex
+	states map[string]JobState
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load(_ context.Context) (map[string]JobState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.read(); err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]JobState, len(s.states))
+	for id, state := range s.states {
+		states[id] = state
+	}
+	return states, nil
+}
+
+func (s *FileStore) Save(_ context.Context, state JobState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.states == nil {
+		if err := s.read(); err != nil {
+			return err
+		}
+	}
+	s.states[state.ID] = state
+
+	return s.write()
+}
+
+func (s *FileStore) read() error {
+	s.states = make(map[string]JobState)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read job state file: %w", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &s.states); err != nil {
+		return fmt.Errorf("failed to decode job state file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) write() error {
+	data, err := json.MarshalIndent(s.states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode job state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create job state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write job state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write job state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace job state file: %w", err)
+	}
+	return nil
+}
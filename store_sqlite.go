@@ -0,0 +1,81 @@
+package agg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const sqliteStoreSchema = `CREATE TABLE IF NOT EXISTS agg_job_state (
+	id                   TEXT PRIMARY KEY,
+	last_run             INTEGER NOT NULL,
+	last_result          TEXT NOT NULL DEFAULT '',
+	last_error           TEXT NOT NULL DEFAULT '',
+	consecutive_failures INTEGER NOT NULL DEFAULT 0
+)`
+
+// SQLiteStore is a JobStore backed by the agg_job_state table of a SQLite
+// database. The caller opens db with the SQLite driver of its choice, which
+// keeps this package free of cgo and driver dependencies.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore creates the state table if needed and returns a store using db.
+func NewSQLiteStore(ctx context.Context, db *sql.DB) (*SQLiteStore, error) {
+	if _, err := db.ExecContext(ctx, sqliteStoreSchema); err != nil {
+		return nil, fmt.Errorf("failed to create job state table: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Load(ctx context.Context) (map[string]JobState, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, last_run, last_result, last_error, consecutive_failures FROM agg_job_state`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query job state: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]JobState)
+	for rows.Next() {
+		var (
+			state   JobState
+			lastRun int64
+		)
+		if err := rows.Scan(&state.ID, &lastRun, &state.LastResult, &state.LastError, &state.ConsecutiveFailures); err != nil {
+			return nil, fmt.Errorf("failed to scan job state: %w", err)
+		}
+		if lastRun != 0 {
+			state.LastRun = time.Unix(0, lastRun)
+		}
+		states[state.ID] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read job state: %w", err)
+	}
+
+	return states, nil
+}
+
+func (s *SQLiteStore) Save(ctx context.Context, state JobState) error {
+	var lastRun int64
+	if !state.LastRun.IsZero() {
+		lastRun = state.LastRun.UnixNano()
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO agg_job_state (id, last_run, last_result, last_error, consecutive_failures)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			last_run = excluded.last_run,
+			last_result = excluded.last_result,
+			last_error = excluded.last_error,
+			consecutive_failures = excluded.consecutive_failures`,
+		state.ID, lastRun, string(state.LastResult), state.LastError, state.ConsecutiveFailures)
+	if err != nil {
+		return fmt.Errorf("failed to save job state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package agg
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func testStoreRoundTrip(t *testing.T, open func() JobStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	states, err := open().Load(ctx)
+	if err != nil || len(states) != 0 {
+		t.Fatalf("Load() of an empty store = %v, %v, want no states", states, err)
+	}
+
+	store := open()
+	saved := []JobState{
+		{ID: "pracuj", LastRun: testEpoch, LastResult: ResultSucceeded},
+		{ID: "nofluff", LastRun: testEpoch.Add(-time.Hour), LastResult: ResultFailed, LastError: "503 Service Unavailable", ConsecutiveFailures: 2},
+		{ID: "pracuj", LastRun: testEpoch.Add(time.Hour), LastResult: ResultTimedOut, ConsecutiveFailures: 1},
+		{ID: "cleanup"},
+	}
+	for _, state := range saved {
+		if err := store.Save(ctx, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states, err = open().Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]JobState{"pracuj": saved[2], "nofluff": saved[1], "cleanup": saved[3]}
+	if len(states) != len(want) {
+		t.Fatalf("Load() = %+v, want %+v", states, want)
+	}
+	for id, w := range want {
+		got := states[id]
+		if !got.LastRun.Equal(w.LastRun) {
+			t.Errorf("%s LastRun = %v, want %v", id, got.LastRun, w.LastRun)
+		}
+		got.LastRun, w.LastRun = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("%s = %+v, want %+v", id, got, w)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jobs-state.json")
+	testStoreRoundTrip(t, func() JobStore { return NewFileStore(path) })
+
+	// Every save replaces the file through a temporary one in the same
+	// directory, which must not be left behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "jobs-state.json" {
+		t.Errorf("directory holds %v, want only jobs-state.json", entries)
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(path).Load(context.Background()); err == nil {
+		t.Error("Load() of a corrupt file succeeded, want error")
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	testStoreRoundTrip(t, func() JobStore {
+		store, err := NewSQLiteStore(context.Background(), db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestStoredLastRunTakesPrecedence(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "jobs-state.json"))
+	stored := testEpoch.Add(-10 * time.Minute)
+	if err := store.Save(context.Background(), JobState{ID: "pracuj", LastRun: stored}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := newTestAggregator(t, 1, WithStore(store))
+	a.AddJob("pracuj", time.Hour, noop, testEpoch)
+	a.AddJob("nofluff", time.Hour, noop, testEpoch)
+	a.Start()
+
+	if next, _ := a.NextRun("pracuj"); !next.Equal(stored.Add(time.Hour)) {
+		t.Errorf("pracuj NextRun = %v, want an hour after the stored LastRun", next)
+	}
+	if next, _ := a.NextRun("nofluff"); !next.Equal(testEpoch.Add(time.Hour)) {
+		t.Errorf("nofluff NextRun = %v, want an hour after the given lastrun", next)
+	}
+}
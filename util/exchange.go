@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -19,9 +20,14 @@ var (
 	rateCache     = make(map[string]exchangeRate)
 	cacheMux      sync.RWMutex
 	cacheDuration = 1 * time.Hour
+	// rateClient bounds the rate requests also when the caller's context
+	// has no deadline.
+	rateClient = &http.Client{Timeout: 30 * time.Second}
 )
 
-func GetExchangeRate(from, to string) (float64, error) {
+// GetExchangeRate returns the NBP mid rate of from in PLN, cached for an hour.
+// Cancelling ctx aborts the request.
+func GetExchangeRate(ctx context.Context, from, to string) (float64, error) {
 	from = strings.ToLower(from)
 
 	cacheMux.RLock()
@@ -33,7 +39,7 @@ func GetExchangeRate(from, to string) (float64, error) {
 	}
 	cacheMux.RUnlock()
 
-	rate, err := fetchExchangeRate(from)
+	rate, err := fetchExchangeRate(ctx, from)
 	if err != nil {
 		return 0, err
 	}
@@ -48,18 +54,17 @@ func GetExchangeRate(from, to string) (float64, error) {
 	return rate, nil
 }
 
-func fetchExchangeRate(from string) (float64, error) {
+func fetchExchangeRate(ctx context.Context, from string) (float64, error) {
 	url := fmt.Sprintf("https://api.nbp.pl/api/exchangerates/rates/a/%s/?format=json", from)
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
 
-	resp, err := client.Do(req)
+	resp, err := rateClient.Do(req)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get exchange rate: %w", err)
 	}
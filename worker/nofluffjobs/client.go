@@ -2,12 +2,15 @@ package nofluffjobs
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
+	"iter"
 	"net/http"
 	"net/url"
 	"strconv"
 
+	"github.com/kabinasoftware/jobs-agg/metrics"
 	"github.com/kabinasoftware/jobs-agg/models"
 	"github.com/kabinasoftware/jobs-agg/worker"
 )
@@ -19,11 +22,18 @@ var (
 type Options struct {
 	BaseURL    string
 	HTTPClient *http.Client
+	// Metrics receives request and offer counters. Defaults to metrics.Default.
+	Metrics *metrics.Registry
+	// RawSearch is the search expression sent to the API, such as
+	// "remote category=backend". Defaults to "remote".
+	RawSearch string
 }
 
 type Worker struct {
 	baseURL    string
 	HTTPClient *http.Client
+	metrics    *metrics.Registry
+	rawSearch  string
 }
 
 func Init(opts *Options) worker.Worker {
@@ -42,13 +52,23 @@ func Init(opts *Options) worker.Worker {
 		opts.BaseURL = APIGatewayURL
 	}
 
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.Default
+	}
+
+	if opts.RawSearch == "" {
+		opts.RawSearch = "remote"
+	}
+
 	return &Worker{
 		baseURL:    opts.BaseURL,
-		HTTPClient: opts.HTTPClient,
+		HTTPClient: metrics.InstrumentClient(opts.HTTPClient, opts.Metrics, Source),
+		metrics:    opts.Metrics,
+		rawSearch:  opts.RawSearch,
 	}
 }
 
-func (w *Worker) GetPagesCount() (int, error) {
+func (w *Worker) GetPagesCount(ctx context.Context) (int, error) {
 	baseURL, err := url.Parse(w.baseURL + "/search/posting")
 	if err != nil {
 		return 0, err
@@ -62,8 +82,7 @@ func (w *Worker) GetPagesCount() (int, error) {
 	params.Add("salaryPeriod", "month")
 	baseURL.RawQuery = params.Encode()
 
-	reqBody := bytes.NewBuffer([]byte(`{"rawSearch": "remote"}`))
-	resp, err := w.HTTPClient.Post(baseURL.String(), "application/json", reqBody)
+	resp, err := w.search(ctx, baseURL.String())
 	if err != nil {
 		return 0, err
 	}
@@ -83,7 +102,43 @@ func (w *Worker) GetPagesCount() (int, error) {
 	return result.TotalPages, nil
 }
 
-func (w *Worker) GetOffers(page int) ([]*models.Offer, error) {
+func (w *Worker) GetOffers(ctx context.Context, page int) ([]*models.Offer, error) {
+	explorer, err := w.listing(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := explorer.Setup(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+	metrics.OffersProduced(w.metrics, Source, len(result))
+
+	return result, nil
+}
+
+// StreamOffers yields the offers on page as their detail pages are fetched.
+func (w *Worker) StreamOffers(ctx context.Context, page int) iter.Seq2[*models.Offer, error] {
+	return func(yield func(*models.Offer, error) bool) {
+		explorer, err := w.listing(ctx, page)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for offer, err := range explorer.Offers(ctx, w) {
+			if err == nil {
+				metrics.OffersProduced(w.metrics, Source, 1)
+			}
+			if !yield(offer, err) {
+				return
+			}
+		}
+	}
+}
+
+// listing fetches the postings on page without their details.
+func (w *Worker) listing(ctx context.Context, page int) (*Offers, error) {
 	baseURL, err := url.Parse(w.baseURL + "/search/posting")
 	if err != nil {
 		return nil, err
@@ -97,8 +152,7 @@ func (w *Worker) GetOffers(page int) ([]*models.Offer, error) {
 	params.Add("salaryPeriod", "month")
 	baseURL.RawQuery = params.Encode()
 
-	reqBody := bytes.NewBuffer([]byte(`{"rawSearch": "remote"}`))
-	resp, err := w.HTTPClient.Post(baseURL.String(), "application/json", reqBody)
+	resp, err := w.search(ctx, baseURL.String())
 	if err != nil {
 		return nil, err
 	}
@@ -115,11 +169,27 @@ func (w *Worker) GetOffers(page int) ([]*models.Offer, error) {
 		return nil, err
 	}
 
-	return explorer.Setup(w), nil
+	return explorer, nil
+}
+
+// search posts the search expression to uri.
+func (w *Worker) search(ctx context.Context, uri string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, w.searchBody())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return w.HTTPClient.Do(req)
+}
+
+func (w *Worker) searchBody() io.Reader {
+	body, _ := json.Marshal(map[string]string{"rawSearch": w.rawSearch})
+	return bytes.NewReader(body)
 }
 
-func (w *Worker) getOffer(uri string) (*Offer, error) {
-	req, err := http.NewRequest("GET", uri, nil)
+func (w *Worker) getOffer(ctx context.Context, uri string) (*Offer, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
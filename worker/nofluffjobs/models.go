@@ -1,7 +1,9 @@
 package nofluffjobs
 
 import (
+	"context"
 	"fmt"
+	"iter"
 	"strings"
 	"time"
 
@@ -127,19 +129,43 @@ type Requirement struct {
 	Type  string `json:"type"`
 }
 
-func (o *Offers) Setup(client *Worker) []*models.Offer {
+// Setup collects the offers yielded by Offers. It fails only if ctx is
+// cancelled.
+func (o *Offers) Setup(ctx context.Context, client *Worker) ([]*models.Offer, error) {
 	offers := make([]*models.Offer, 0)
+	for offer, err := range o.Offers(ctx, client) {
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, offer)
+	}
+	return offers, nil
+}
+
+// Offers yields the postings of the listing as their detail pages are
+// fetched. Postings whose details cannot be fetched are logged and skipped.
+func (o *Offers) Offers(ctx context.Context, client *Worker) iter.Seq2[*models.Offer, error] {
+	return func(yield func(*models.Offer, error) bool) {
+		o.walk(ctx, client, yield)
+	}
+}
+
+func (o *Offers) walk(ctx context.Context, client *Worker, yield func(*models.Offer, error) bool) {
 	requestCount := 0
 
 	for _, posting := range o.Postings {
 		requestCount++
-		offer, err := client.getOffer(fmt.Sprintf("%s/posting/%s", APIGatewayURL, posting.ID))
+		offer, err := client.getOffer(ctx, fmt.Sprintf("%s/posting/%s", client.baseURL, posting.ID))
+		if ctx.Err() != nil {
+			yield(nil, ctx.Err())
+			return
+		}
 		if err != nil {
 			slog.Error("error getting offer",
 				"error", err.Error(),
 				"request_count", requestCount,
 				"layer", "agg_worker")
-			return nil
+			continue
 		}
 
 		src := Source
@@ -180,7 +206,7 @@ func (o *Offers) Setup(client *Worker) []*models.Offer {
 			}
 
 			if salary.Currency != "PLN" {
-				cur, rateErr := util.GetExchangeRate(salary.Currency, "PLN")
+				cur, rateErr := util.GetExchangeRate(ctx, salary.Currency, "PLN")
 				if rateErr != nil {
 					slog.Error("failed to get exchange rate", "error", rateErr.Error(), "layer", "agg_worker")
 					continue
@@ -263,11 +289,12 @@ func (o *Offers) Setup(client *Worker) []*models.Offer {
 			newOffer.Contracts = append(newOffer.Contracts, models.ContractTypeIDUmowaOPrace)
 		}
 
-		offers = append(offers, newOffer)
+		if !yield(newOffer, nil) {
+			return
+		}
 	}
 
 	slog.Info("completed processing offers", "total_requests", requestCount)
-	return offers
 }
 
 type Offers struct {
@@ -1,13 +1,16 @@
 package pracuj
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"iter"
 	"net/http"
 	"net/url"
 	"regexp"
 
+	"github.com/kabinasoftware/jobs-agg/metrics"
 	"github.com/kabinasoftware/jobs-agg/models"
 	"github.com/kabinasoftware/jobs-agg/worker"
 )
@@ -20,11 +23,17 @@ var (
 type Options struct {
 	BaseURL    string
 	HTTPClient *http.Client
+	// Metrics receives request and offer counters. Defaults to metrics.Default.
+	Metrics *metrics.Registry
+	// Query holds the listing search filters. Defaults to remote offers only.
+	Query url.Values
 }
 
 type Worker struct {
 	baseURL    string
 	HTTPClient *http.Client
+	metrics    *metrics.Registry
+	query      url.Values
 }
 
 func Init(opts *Options) worker.Worker {
@@ -43,29 +52,77 @@ func Init(opts *Options) worker.Worker {
 		opts.BaseURL = APIGatewayURL
 	}
 
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.Default
+	}
+
+	if opts.Query == nil {
+		opts.Query = url.Values{"wm": {"home-office"}}
+	}
+
 	return &Worker{
 		baseURL:    opts.BaseURL,
-		HTTPClient: opts.HTTPClient,
+		HTTPClient: metrics.InstrumentClient(opts.HTTPClient, opts.Metrics, Source),
+		metrics:    opts.Metrics,
+		query:      opts.Query,
 	}
 }
 
 // TODO:
-func (w *Worker) GetPagesCount() (int, error) {
+func (w *Worker) GetPagesCount(ctx context.Context) (int, error) {
 	return 1000, nil
 }
 
-func (w *Worker) GetOffers(page int) (offer []*models.Offer, x error) {
+func (w *Worker) GetOffers(ctx context.Context, page int) (offer []*models.Offer, x error) {
+	offers, err := w.listing(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := offers.Setup(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+	metrics.OffersProduced(w.metrics, Source, len(result))
+
+	return result, nil
+}
+
+// StreamOffers yields the offers on page as their detail pages are fetched.
+func (w *Worker) StreamOffers(ctx context.Context, page int) iter.Seq2[*models.Offer, error] {
+	return func(yield func(*models.Offer, error) bool) {
+		offers, err := w.listing(ctx, page)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for offer, err := range offers.Offers(ctx, w) {
+			if err == nil {
+				metrics.OffersProduced(w.metrics, Source, 1)
+			}
+			if !yield(offer, err) {
+				return
+			}
+		}
+	}
+}
+
+// listing fetches the grouped offers on page without their details.
+func (w *Worker) listing(ctx context.Context, page int) (*Offers, error) {
 	baseURL, err := url.Parse(w.baseURL + "/JobOffers/listing/grouped")
 	if err != nil {
 		return nil, err
 	}
 
 	params := url.Values{}
-	params.Add("wm", "home-office")
-	params.Add("pn", fmt.Sprintf("%d", page))
+	for key, values := range w.query {
+		params[key] = values
+	}
+	params.Set("pn", fmt.Sprintf("%d", page))
 	baseURL.RawQuery = params.Encode()
 
-	req, err := http.NewRequest("GET", baseURL.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL.String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -87,11 +144,11 @@ func (w *Worker) GetOffers(page int) (offer []*models.Offer, x error) {
 		return nil, err
 	}
 
-	return offers.Setup(w), nil
+	return offers, nil
 }
 
-func (w *Worker) getOffer(uri string) (*Offer, error) {
-	req, err := http.NewRequest("GET", uri, nil)
+func (w *Worker) getOffer(ctx context.Context, uri string) (*Offer, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,8 @@
 package pracuj
 
 import (
+	"context"
+	"iter"
 	"log/slog"
 	"strconv"
 	"strings"
@@ -85,13 +87,36 @@ type (
 	}
 )
 
-func (o *Offers) Setup(client *Worker) []*models.Offer {
+// Setup collects the offers yielded by Offers. It fails only if ctx is
+// cancelled.
+func (o *Offers) Setup(ctx context.Context, client *Worker) ([]*models.Offer, error) {
 	pracaOffers := make([]*models.Offer, 0)
+	for offer, err := range o.Offers(ctx, client) {
+		if err != nil {
+			return nil, err
+		}
+		pracaOffers = append(pracaOffers, offer)
+	}
+	return pracaOffers, nil
+}
 
+// Offers yields the offers of the listing as their detail pages are
+// fetched. Offers whose details cannot be fetched are logged and skipped.
+func (o *Offers) Offers(ctx context.Context, client *Worker) iter.Seq2[*models.Offer, error] {
+	return func(yield func(*models.Offer, error) bool) {
+		o.walk(ctx, client, yield)
+	}
+}
+
+func (o *Offers) walk(ctx context.Context, client *Worker, yield func(*models.Offer, error) bool) {
 	for _, groupedOffer := range o.GroupedOffers {
 		if len(groupedOffer.Offers) > 0 {
 			offer := groupedOffer.Offers[0]
-			off, err := client.getOffer(offer.OfferAbsoluteURI)
+			off, err := client.getOffer(ctx, offer.OfferAbsoluteURI)
+			if ctx.Err() != nil {
+				yield(nil, ctx.Err())
+				return
+			}
 			if err != nil {
 				slog.Error("failed to get offer", "error", err.Error(), "layer", "agg_worker")
 				continue
@@ -149,7 +174,7 @@ func (o *Offers) Setup(client *Worker) []*models.Offer {
 					}
 
 					if cur != "" {
-						currency, err := util.GetExchangeRate(cur, "PLN")
+						currency, err := util.GetExchangeRate(ctx, cur, "PLN")
 						if err != nil {
 							slog.Error("failed to get exchange rate", "error", err.Error(), "layer", "agg_worker")
 							continue
@@ -217,9 +242,9 @@ func (o *Offers) Setup(client *Worker) []*models.Offer {
 				}
 			}
 
-			pracaOffers = append(pracaOffers, newOffer)
+			if !yield(newOffer, nil) {
+				return
+			}
 		}
 	}
-
-	return pracaOffers
 }
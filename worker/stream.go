@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/kabinasoftware/jobs-agg/models"
+)
+
+// Streamer is implemented by workers that can yield the offers on a page as
+// their detail pages are fetched, instead of once the whole page is ready.
+type Streamer interface {
+	StreamOffers(ctx context.Context, page int) iter.Seq2[*models.Offer, error]
+}
+
+// Stream walks the pages of w lazily and yields their offers. It stops after
+// the first empty page or error; when ctx is cancelled it yields ctx.Err()
+// and stops.
+func Stream(ctx context.Context, w Worker) iter.Seq2[*models.Offer, error] {
+	return func(yield func(*models.Offer, error) bool) {
+		pages, err := w.GetPagesCount(ctx)
+		if err != nil {
+			yield(nil, streamError(ctx, fmt.Errorf("failed to get pages count: %w", err)))
+			return
+		}
+
+		for page := 1; page <= pages; page++ {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+
+			empty := true
+			for offer, err := range offersOn(ctx, w, page) {
+				if err != nil {
+					yield(nil, streamError(ctx, fmt.Errorf("failed to get offers page %d: %w", page, err)))
+					return
+				}
+				empty = false
+				if !yield(offer, nil) {
+					return
+				}
+			}
+			if empty {
+				return
+			}
+		}
+	}
+}
+
+// offersOn yields the offers on page, streaming them when w supports it.
+func offersOn(ctx context.Context, w Worker, page int) iter.Seq2[*models.Offer, error] {
+	if s, ok := w.(Streamer); ok {
+		return s.StreamOffers(ctx, page)
+	}
+	return func(yield func(*models.Offer, error) bool) {
+		offers, err := w.GetOffers(ctx, page)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for _, offer := range offers {
+			if !yield(offer, nil) {
+				return
+			}
+		}
+	}
+}
+
+// streamError reports a cancelled ctx as ctx.Err() rather than as the
+// failed request it caused.
+func streamError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
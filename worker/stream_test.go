@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kabinasoftware/jobs-agg/models"
+)
+
+// pagedWorker serves pages of offers; pages past the end are empty.
+type pagedWorker struct {
+	pages   [][]string
+	fetched []int
+	cancel  context.CancelFunc
+}
+
+func (w *pagedWorker) GetPagesCount(context.Context) (int, error) {
+	return 1000, nil
+}
+
+func (w *pagedWorker) GetOffers(ctx context.Context, page int) ([]*models.Offer, error) {
+	w.fetched = append(w.fetched, page)
+	if w.cancel != nil && page == 2 {
+		w.cancel()
+		return nil, ctx.Err()
+	}
+	if page > len(w.pages) {
+		return nil, nil
+	}
+	var offers []*models.Offer
+	for _, title := range w.pages[page-1] {
+		offers = append(offers, &models.Offer{Title: title})
+	}
+	return offers, nil
+}
+
+func TestStreamStopsOnEmptyPageAndCancellation(t *testing.T) {
+	w := &pagedWorker{pages: [][]string{{"go", "rust"}, {"zig"}}}
+
+	var titles []string
+	for offer, err := range Stream(context.Background(), w) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles = append(titles, offer.Title)
+	}
+	if len(titles) != 3 || titles[2] != "zig" {
+		t.Errorf("titles = %v, want go, rust, zig", titles)
+	}
+	if len(w.fetched) != 3 {
+		t.Errorf("fetched pages %v, want to stop after the empty page 3", w.fetched)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w = &pagedWorker{pages: [][]string{{"go"}, {"rust"}, {"zig"}}, cancel: cancel}
+
+	titles = nil
+	var got error
+	for offer, err := range Stream(ctx, w) {
+		if err != nil {
+			got = err
+			break
+		}
+		titles = append(titles, offer.Title)
+	}
+	if !errors.Is(got, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", got)
+	}
+	if len(titles) != 1 || len(w.fetched) != 2 {
+		t.Errorf("titles = %v after pages %v, want only page 1", titles, w.fetched)
+	}
+}
+
+// streamingWorker streams pages of offers and fails page failPage after
+// failAfter offers. Its GetOffers must never be used.
+type streamingWorker struct {
+	pages     [][]string
+	failPage  int
+	failAfter int
+	streamed  []int
+	stopped   bool
+}
+
+func (w *streamingWorker) GetPagesCount(context.Context) (int, error) {
+	return len(w.pages), nil
+}
+
+func (w *streamingWorker) GetOffers(context.Context, int) ([]*models.Offer, error) {
+	panic("GetOffers called on a Streamer")
+}
+
+func (w *streamingWorker) StreamOffers(_ context.Context, page int) iter.Seq2[*models.Offer, error] {
+	return func(yield func(*models.Offer, error) bool) {
+		w.streamed = append(w.streamed, page)
+		for i, title := range w.pages[page-1] {
+			if page == w.failPage && i == w.failAfter {
+				yield(nil, errors.New("503 Service Unavailable"))
+				return
+			}
+			if !yield(&models.Offer{Title: title}, nil) {
+				w.stopped = true
+				return
+			}
+		}
+	}
+}
+
+func TestStreamUsesStreamer(t *testing.T) {
+	collect := func(w *streamingWorker, limit int) ([]string, error) {
+		var titles []string
+		for offer, err := range Stream(context.Background(), w) {
+			if err != nil {
+				return titles, err
+			}
+			titles = append(titles, offer.Title)
+			if len(titles) == limit {
+				break
+			}
+		}
+		return titles, nil
+	}
+
+	w := &streamingWorker{pages: [][]string{{"go", "rust"}, {"zig", "odin", "c"}, {"java"}}, failPage: 2, failAfter: 1}
+	titles, err := collect(w, 0)
+	if err == nil || !strings.Contains(err.Error(), "page 2: 503") {
+		t.Errorf("error = %v, want the page 2 failure", err)
+	}
+	if !slices.Equal(titles, []string{"go", "rust", "zig"}) {
+		t.Errorf("titles = %v, want the offers before the failure", titles)
+	}
+	if !slices.Equal(w.streamed, []int{1, 2}) {
+		t.Errorf("streamed pages %v, want to stop at page 2", w.streamed)
+	}
+
+	w = &streamingWorker{pages: [][]string{{"go", "rust"}, {"zig"}}}
+	titles, err = collect(w, 1)
+	if err != nil || !slices.Equal(titles, []string{"go"}) {
+		t.Errorf("collect() = %v, %v, want only go", titles, err)
+	}
+	if !w.stopped || !slices.Equal(w.streamed, []int{1}) {
+		t.Errorf("stopped = %v after pages %v, want the first page stopped early", w.stopped, w.streamed)
+	}
+
+	w = &streamingWorker{pages: [][]string{{"go"}, {}, {"zig"}}}
+	if titles, err = collect(w, 0); err != nil || !slices.Equal(titles, []string{"go"}) {
+		t.Errorf("collect() = %v, %v, want to stop at the empty page", titles, err)
+	}
+}
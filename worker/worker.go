@@ -1,8 +1,62 @@
 package worker
 
-import "github.com/kabinasoftware/jobs-agg/models"
+import (
+	"context"
 
+	"github.com/kabinasoftware/jobs-agg/models"
+)
+
+// Worker fetches offers from a single source. Cancelling ctx aborts the
+// requests in flight, including the per-offer detail fetches.
 type Worker interface {
+	GetOffers(ctx context.Context, page int) ([]*models.Offer, error)
+	GetPagesCount(ctx context.Context) (totalPages int, err error)
+}
+
+// LegacyWorker is the context-free Worker interface of earlier releases.
+type LegacyWorker interface {
 	GetOffers(page int) ([]*models.Offer, error)
-	GetPagesCount() (totalPages int, error error)
+	GetPagesCount() (totalPages int, err error)
+}
+
+// FromLegacy adapts a LegacyWorker to Worker. Its requests cannot be
+// cancelled, so ctx is only checked before each call.
+func FromLegacy(w LegacyWorker) Worker {
+	return legacyWorker{w}
+}
+
+type legacyWorker struct {
+	w LegacyWorker
+}
+
+func (l legacyWorker) GetOffers(ctx context.Context, page int) ([]*models.Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return l.w.GetOffers(page)
+}
+
+func (l legacyWorker) GetPagesCount(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return l.w.GetPagesCount()
+}
+
+// WithoutContext adapts w for callers of the LegacyWorker interface. Its
+// requests run with context.Background().
+func WithoutContext(w Worker) LegacyWorker {
+	return backgroundWorker{w}
+}
+
+type backgroundWorker struct {
+	w Worker
+}
+
+func (b backgroundWorker) GetOffers(page int) ([]*models.Offer, error) {
+	return b.w.GetOffers(context.Background(), page)
+}
+
+func (b backgroundWorker) GetPagesCount() (int, error) {
+	return b.w.GetPagesCount(context.Background())
 }
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kabinasoftware/jobs-agg/models"
+)
+
+// legacyPages is a LegacyWorker with a single page of offers.
+type legacyPages struct {
+	calls int
+}
+
+func (w *legacyPages) GetOffers(page int) ([]*models.Offer, error) {
+	w.calls++
+	return []*models.Offer{{Title: "go"}}, nil
+}
+
+func (w *legacyPages) GetPagesCount() (int, error) {
+	w.calls++
+	return 1, nil
+}
+
+// contextWorker records the contexts it is called with.
+type contextWorker struct {
+	contexts []context.Context
+}
+
+func (w *contextWorker) GetOffers(ctx context.Context, page int) ([]*models.Offer, error) {
+	w.contexts = append(w.contexts, ctx)
+	return []*models.Offer{{Title: "go"}}, nil
+}
+
+func (w *contextWorker) GetPagesCount(ctx context.Context) (int, error) {
+	w.contexts = append(w.contexts, ctx)
+	return 1, nil
+}
+
+func TestFromLegacy(t *testing.T) {
+	legacy := &legacyPages{}
+	w := FromLegacy(legacy)
+
+	if pages, err := w.GetPagesCount(context.Background()); err != nil || pages != 1 {
+		t.Fatalf("GetPagesCount() = %d, %v, want 1", pages, err)
+	}
+	if offers, err := w.GetOffers(context.Background(), 1); err != nil || len(offers) != 1 {
+		t.Fatalf("GetOffers() = %v, %v, want one offer", offers, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := w.GetPagesCount(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetPagesCount() with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+	if _, err := w.GetOffers(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetOffers() with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+	if legacy.calls != 2 {
+		t.Errorf("legacy worker called %d times, want 2", legacy.calls)
+	}
+}
+
+func TestWithoutContext(t *testing.T) {
+	w := &contextWorker{}
+	legacy := WithoutContext(w)
+
+	if pages, err := legacy.GetPagesCount(); err != nil || pages != 1 {
+		t.Fatalf("GetPagesCount() = %d, %v, want 1", pages, err)
+	}
+	if offers, err := legacy.GetOffers(1); err != nil || len(offers) != 1 {
+		t.Fatalf("GetOffers() = %v, %v, want one offer", offers, err)
+	}
+	for _, ctx := range w.contexts {
+		if ctx != context.Background() {
+			t.Errorf("called with %v, want context.Background()", ctx)
+		}
+	}
+	if len(w.contexts) != 2 {
+		t.Errorf("worker called %d times, want 2", len(w.contexts))
+	}
+}